@@ -0,0 +1,184 @@
+//go:build !race
+
+// sync.Pool drops the buffers at random under the race detector, so allocations are not counted there
+
+package compose
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the values are big enough not to be boxed without allocations by the runtime
+
+// resultSlice is allocated by a composed function when its results don't fit into the slice
+// of its arguments, it can't be pooled as reflect reads it after the function returns
+const resultSlice = 1
+
+var errAllocOdd = errors.New("odd")
+
+func allocInc(a int) int { return a + 1 }
+
+func allocDouble(a int) int { return a * 2 }
+
+func allocSplit(a int) (int, int) { return a / 2, a - a/2 }
+
+func allocHalf(a int) (int, error) {
+	if a%2 != 0 {
+		return 0, errAllocOdd
+	}
+	return a / 2, nil
+}
+
+func allocHalfContext(_ context.Context, a int) (int, error) {
+	return allocHalf(a)
+}
+
+// allocs counts the allocations of calling fn with the arguments through reflect
+func allocs(fn interface{}, args ...interface{}) float64 {
+	call := reflect.ValueOf(fn).Call
+	values := make([]reflect.Value, 0, len(args))
+	for _, arg := range args {
+		values = append(values, reflect.ValueOf(arg))
+	}
+	return testing.AllocsPerRun(100, func() { call(values) })
+}
+
+// step is a call of a function inside of a composed function
+type step struct {
+	fn   interface{}
+	args []interface{}
+}
+
+// floor counts the allocations made by reflect itself when a function of the type of fn made
+// by reflect.MakeFunc is called with the arguments and calls the steps,
+// composed functions are expected to allocate no more than that
+func floor(fn interface{}, args []interface{}, steps ...step) float64 {
+	fnType := reflect.TypeOf(fn)
+	outTypes, _ := out(fnType)
+	results := zeros(outTypes)
+	constant := reflect.MakeFunc(fnType, func([]reflect.Value) []reflect.Value {
+		return results
+	}).Interface()
+	total := allocs(constant, args...)
+	for _, s := range steps {
+		total += allocs(s.fn, s.args...)
+	}
+	return total
+}
+
+func TestAllocs_Chain(t *testing.T) {
+	chained := Chain(allocInc, allocDouble, allocInc)
+	expected := floor(chained, []interface{}{1000},
+		step{allocInc, []interface{}{1000}},
+		step{allocDouble, []interface{}{1001}},
+		step{allocInc, []interface{}{2002}},
+	)
+	assert.LessOrEqual(t, allocs(chained, 1000), expected)
+}
+
+func TestAllocs_Stack(t *testing.T) {
+	stacked := Stack(allocInc, allocDouble, allocInc)
+	expected := floor(stacked, []interface{}{1000, 2000, 3000},
+		step{allocInc, []interface{}{1000}},
+		step{allocDouble, []interface{}{2000}},
+		step{allocInc, []interface{}{3000}},
+	)
+	assert.LessOrEqual(t, allocs(stacked, 1000, 2000, 3000), expected)
+}
+
+func TestAllocs_StackMoreOutputs(t *testing.T) {
+	// the outputs don't fit into the arguments
+	stacked := Stack(allocSplit, allocInc)
+	expected := floor(stacked, []interface{}{1000, 2000},
+		step{allocSplit, []interface{}{1000}},
+		step{allocInc, []interface{}{2000}},
+	)
+	assert.LessOrEqual(t, allocs(stacked, 1000, 2000), expected+resultSlice)
+}
+
+func TestAllocs_ChainWithError(t *testing.T) {
+	chained := ChainWithError(allocHalf, allocHalf)
+	expected := floor(chained, []interface{}{1000},
+		step{allocHalf, []interface{}{1000}},
+		step{allocHalf, []interface{}{500}},
+	)
+	assert.LessOrEqual(t, allocs(chained, 1000), expected)
+}
+
+func TestAllocs_ChainWithErrorFailed(t *testing.T) {
+	chained := ChainWithError(allocHalf, allocHalf)
+	expected := floor(chained, []interface{}{1002},
+		step{allocHalf, []interface{}{1002}},
+		step{allocHalf, []interface{}{501}},
+	)
+	assert.LessOrEqual(t, allocs(chained, 1002), expected)
+}
+
+func TestAllocs_StackWithError(t *testing.T) {
+	stacked := StackWithError(allocHalf, allocHalf)
+	expected := floor(stacked, []interface{}{1000, 2000},
+		step{allocHalf, []interface{}{1000}},
+		step{allocHalf, []interface{}{2000}},
+	)
+	// the outputs followed by the error don't fit into the arguments
+	assert.LessOrEqual(t, allocs(stacked, 1000, 2000), expected+resultSlice)
+}
+
+func TestAllocs_ChainContext(t *testing.T) {
+	ctx := context.Background()
+	chained := ChainContext(allocHalfContext, allocHalf)
+	expected := floor(chained, []interface{}{ctx, 1000},
+		step{allocHalfContext, []interface{}{ctx, 1000}},
+		step{allocHalf, []interface{}{500}},
+	)
+	assert.LessOrEqual(t, allocs(chained, ctx, 1000), expected)
+}
+
+func TestAllocs_StackContext(t *testing.T) {
+	ctx := context.Background()
+	stacked := StackContext(allocHalfContext, allocHalf)
+	expected := floor(stacked, []interface{}{ctx, 1000, 2000},
+		step{allocHalfContext, []interface{}{ctx, 1000}},
+		step{allocHalf, []interface{}{2000}},
+	)
+	assert.LessOrEqual(t, allocs(stacked, ctx, 1000, 2000), expected)
+}
+
+func TestAllocs_Compile(t *testing.T) {
+	compiled := Compile(ladder(t, 10), AllArgs{})
+	steps := make([]step, 0)
+	a, b := 1000, 2000
+	for i := 0; i < 10; i++ {
+		steps = append(steps, step{add, []interface{}{a, b}})
+		a, b = b, a+b
+	}
+	assert.LessOrEqual(t, allocs(compiled, 1000, 2000), floor(compiled, []interface{}{1000, 2000}, steps...))
+}
+
+func TestAllocs_CompileWithError(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	gb.Named("h1", allocHalf).Inputs("a")
+	gb.Named("h2", allocHalf).Inputs("h1")
+	gb.Named("h3", allocHalf).Inputs("h2")
+	gb.Outputs("h3", "h1")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+	steps := []step{
+		{allocHalf, []interface{}{4096}},
+		{allocHalf, []interface{}{2048}},
+		{allocHalf, []interface{}{1024}},
+	}
+
+	// the outputs followed by the error don't fit into the arguments
+	compiled := Compile(g, LastArgError{})
+	assert.LessOrEqual(t, allocs(compiled, 4096), floor(compiled, []interface{}{4096}, steps...)+resultSlice)
+	ctx := context.Background()
+	compiled = Compile(g, ContextArgs{})
+	assert.LessOrEqual(t, allocs(compiled, ctx, 4096), floor(compiled, []interface{}{ctx, 4096}, steps...)+resultSlice)
+}
@@ -1,11 +1,25 @@
 package builder
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/grihabor/gush/internal/dag"
+	"github.com/grihabor/gush/internal/errs"
 )
 
 type GraphBuilder struct {
-	nodes []*Node
+	inputs  []argument
+	nodes   []*Node
+	outputs []interface{}
+}
+
+// argument is an input of the graph declared with GraphBuilder.Input
+type argument struct {
+	name string
+	typ  reflect.Type
 }
 
 func NewGraphBuilder() *GraphBuilder {
@@ -18,32 +32,269 @@ func (g *GraphBuilder) Node(fn interface{}) *Node {
 	return node
 }
 
+// Named adds a node which can be referred to by name in Inputs.
+// Unlike Node, the same function can be added several times under different names.
+func (g *GraphBuilder) Named(name string, fn interface{}) *Node {
+	node := &Node{name: name, named: true, fn: fn}
+	g.nodes = append(g.nodes, node)
+	return node
+}
+
+// Input declares an argument of the compiled graph, which nodes can take by name.
+// Declared inputs become the arguments of the compiled function in the given order,
+// the nodes which don't take their inputs from the other nodes must not take any arguments then.
+func (g *GraphBuilder) Input(name string, typ reflect.Type) {
+	g.inputs = append(g.inputs, argument{name: name, typ: typ})
+}
+
+// Outputs declares values returned by the compiled graph in the given order.
+// Every output is a node function, a node name or a single value selected with Out,
+// functions must be added to the graph as nodes or inputs of the nodes.
+// If outputs are not declared, the graph returns values of the nodes calculated last.
+func (g *GraphBuilder) Outputs(outputs ...interface{}) {
+	g.outputs = outputs
+}
+
+// Ref refers to a single value returned by a node, see Out
+type Ref struct {
+	node  interface{}
+	index int
+}
+
+// Out refers to the value at index returned by the node,
+// which is given either by function or by name
+func Out(node interface{}, index int) Ref {
+	return Ref{node: node, index: index}
+}
+
+// reference is an input resolved after all the nodes are inserted, see deferred
+type reference struct {
+	nodeIndex, edgeIndex int
+	input                interface{}
+}
+
+// deferred reports whether the input is resolved after all the nodes are inserted:
+// it refers to a node by name or to a function added with Named
+func (g *GraphBuilder) deferred(input interface{}) bool {
+	switch r := input.(type) {
+	case Ref:
+		return g.deferred(r.node)
+	case string:
+		return true
+	}
+	for _, node := range g.nodes {
+		if node.named && sameFunc(node.fn, input) {
+			return true
+		}
+	}
+	return false
+}
+
 func (g *GraphBuilder) SafeBuild() (*Graph, error) {
 	p := &Graph{}
+	for inputIndex, input := range g.inputs {
+		if input.typ == nil {
+			return nil, fmt.Errorf("input %q at #%d has no type", input.name, inputIndex)
+		}
+		step := Step{Index: inputIndex, Node: fmt.Sprintf("input %q", input.name)}
+		i, err := p.insertNamed(input.name, passThrough(input.typ), step)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert input %q at #%d: %w", input.name, inputIndex, err)
+		}
+		p.arguments = append(p.arguments, i)
+	}
+	indices := make([]int, 0, len(g.nodes))
+	references := make([]reference, 0)
 	for nodeIndex, node := range g.nodes {
+		if node.named && node.name == "" {
+			return nil, fmt.Errorf("node %v at #%d has an empty name", node.fn, nodeIndex)
+		}
 		nodeFn := node.fn
-		i, err := p.insert(nodeFn)
+		var (
+			i   int
+			err error
+		)
+		// builder nodes are identified by their index and name until they are inserted
+		step := Step{Index: nodeIndex}
+		if node.name != "" {
+			step.Node = strconv.Quote(node.name)
+			i, err = p.insertNamed(node.name, nodeFn, step)
+		} else {
+			i, err = p.insert(nodeFn, step)
+		}
 		if err != nil {
 			return nil, fmt.Errorf(
 				"failed to insert node %v at #%d: %w",
 				nodeFn, nodeIndex, err,
 			)
 		}
+		indices = append(indices, i)
 		for inputIndex, input := range node.inputs {
-			j, err := p.insert(input)
+			if g.deferred(input) {
+				references = append(references, reference{i, len(p.edge[i]), input})
+				p.connect(i, Port{Node: -1, Index: AllValues})
+				continue
+			}
+			port, err := p.resolve(input, true, Step{Index: inputIndex})
 			if err != nil {
 				return nil, fmt.Errorf(
 					"failed to insert input %v at #%d for node %v at #%d: %w",
 					input, inputIndex, nodeFn, nodeIndex, err,
 				)
 			}
-			// p.edge[i] is created during p.insert
-			p.edge[i] = append(p.edge[i], j)
+			p.connect(i, port)
 		}
 	}
+	for _, ref := range references {
+		port, err := p.resolve(ref.input, true, Step{Index: ref.edgeIndex})
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve input %v of node %s: %w", ref.input, p.describe(ref.nodeIndex), err)
+		}
+		p.edge[ref.nodeIndex][ref.edgeIndex] = port.Node
+		p.ports[ref.nodeIndex][ref.edgeIndex] = port
+	}
+	for outputIndex, output := range g.outputs {
+		port, err := p.resolve(output, false, Step{Index: outputIndex})
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve output %v at #%d: %w", output, outputIndex, err)
+		}
+		p.outputs = append(p.outputs, port)
+	}
+	for nodeIndex, i := range indices {
+		if len(g.nodes[nodeIndex].inputs) > 0 {
+			if err := p.checkArguments(i); err != nil {
+				return nil, fmt.Errorf("inputs of node #%d don't match its arguments: %w", nodeIndex, err)
+			}
+		}
+		if memo := g.nodes[nodeIndex].memo; memo != nil && p.memoized[i] == nil {
+			memoized, err := memoize(p.node[i], *memo)
+			if err != nil {
+				return nil, fmt.Errorf("failed to memoize node %s: %w", p.describe(i), err)
+			}
+			p.memoized[i] = memoized
+		}
+	}
+	if cycle := dag.FindCycle(p.NodeCount(), p.Inputs); cycle != nil {
+		return nil, errs.NewCycleError(cycle, p.describe)
+	}
 	return p, nil
 }
 
+var (
+	errorInterface   = reflect.TypeOf((*error)(nil)).Elem()
+	contextInterface = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// passThrough returns a function which returns its argument of type typ as is
+func passThrough(typ reflect.Type) interface{} {
+	fnType := reflect.FuncOf([]reflect.Type{typ}, []reflect.Type{typ}, false)
+	return reflect.MakeFunc(fnType, func(args []reflect.Value) []reflect.Value {
+		return args
+	}).Interface()
+}
+
+// checkArguments makes sure the inputs of the node feed every argument of its function
+// exactly once with a value of the assignable type
+func (g *Graph) checkArguments(idx int) error {
+	_, _, err := g.params(idx)
+	return err
+}
+
+// portTypes returns types of all the values the port refers to, including the error
+func (g *Graph) portTypes(port Port) []reflect.Type {
+	fnType := reflect.TypeOf(g.node[port.Node])
+	if port.Index != AllValues {
+		return []reflect.Type{fnType.Out(port.Index)}
+	}
+	result := make([]reflect.Type, 0, fnType.NumOut())
+	for i := 0; i < fnType.NumOut(); i++ {
+		result = append(result, fnType.Out(i))
+	}
+	return result
+}
+
+// params returns types of the arguments the node function takes from its inputs
+// and the number of arguments taken from every input.
+// A trailing error of an input and a leading context of the function may be excluded.
+func (g *Graph) params(idx int) ([]reflect.Type, []int, error) {
+	ports := g.ports[idx]
+	all, values := make([]int, 0, len(ports)), make([]int, 0, len(ports))
+	allCount, valuesCount := 0, 0
+	for _, port := range ports {
+		portTypes := g.portTypes(port)
+		numOut := len(portTypes)
+		all = append(all, numOut)
+		allCount += numOut
+		if port.Index == AllValues && numOut > 0 && portTypes[numOut-1].Implements(errorInterface) {
+			numOut--
+		}
+		values = append(values, numOut)
+		valuesCount += numOut
+	}
+	fnType := reflect.TypeOf(g.node[idx])
+	params := make([]reflect.Type, 0, fnType.NumIn())
+	for i := 0; i < fnType.NumIn(); i++ {
+		params = append(params, fnType.In(i))
+	}
+	candidates := [][]reflect.Type{params}
+	if len(params) > 0 && params[0] == contextInterface {
+		// context may be passed to the function separately
+		candidates = append(candidates, params[1:])
+	}
+	var typeErr error
+	for _, args := range candidates {
+		for _, counts := range [][]int{all, values} {
+			if len(args) != sum(counts) {
+				continue
+			}
+			err := g.assignable(idx, ports, counts, args)
+			if err == nil {
+				return args, counts, nil
+			}
+			if typeErr == nil {
+				typeErr = err
+			}
+		}
+	}
+	if typeErr != nil {
+		return nil, nil, typeErr
+	}
+	return nil, nil, &ArityMismatchError{
+		Step:     Step{Index: idx, Node: g.describe(idx)},
+		Function: fnType,
+		Given:    allCount,
+		Expected: len(params),
+	}
+}
+
+// assignable checks that the first counts[i] values of every port can be passed as args of the node
+func (g *Graph) assignable(idx int, ports []Port, counts []int, args []reflect.Type) error {
+	arg := 0
+	for i, port := range ports {
+		for _, typ := range g.portTypes(port)[:counts[i]] {
+			if !typ.AssignableTo(args[arg]) {
+				err := &TypeMismatchError{
+					Step:     Step{Index: idx, Node: g.describe(idx)},
+					Arg:      arg,
+					Given:    typ,
+					Expected: args[arg],
+				}
+				return fmt.Errorf("input %s: %w", g.describe(port.Node), err)
+			}
+			arg++
+		}
+	}
+	return nil
+}
+
+func sum(numbers []int) int {
+	result := 0
+	for _, n := range numbers {
+		result += n
+	}
+	return result
+}
+
 func (g *GraphBuilder) Build() (*Graph, error) {
 	graph, err := g.SafeBuild()
 	if err != nil {
@@ -52,11 +303,18 @@ func (g *GraphBuilder) Build() (*Graph, error) {
 	return graph, nil
 }
 
+// Inputs sets functions whose outputs are passed to the node,
+// nodes added with GraphBuilder.Named are referred to by name or by function
+// if it is added under a single name, single values are selected with Out
 func (f *Node) Inputs(inputs ...interface{}) {
 	f.inputs = inputs
 }
 
 type Node struct {
+	name string
+	// named is set for the nodes added with GraphBuilder.Named
+	named  bool
 	fn     interface{}
 	inputs []interface{}
+	memo   *memoization
 }
@@ -0,0 +1,13 @@
+package builder
+
+import "github.com/grihabor/gush/internal/errs"
+
+// Errors returned when the graph can't be built,
+// they are the same types as the ones in the compose package
+type (
+	Step               = errs.Step
+	NotAFunctionError  = errs.NotAFunctionError
+	ArityMismatchError = errs.ArityMismatchError
+	TypeMismatchError  = errs.TypeMismatchError
+	CycleError         = errs.CycleError
+)
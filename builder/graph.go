@@ -3,14 +3,38 @@ package builder
 import (
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/grihabor/gush/internal/funcinfo"
 )
 
+// AllValues is used as Port index to refer to all the values returned by a node
+const AllValues = -1
+
+// Port refers to a value returned by the node at index Node
+type Port struct {
+	Node  int
+	Index int
+}
+
 type Graph struct {
 	// node store functions of the graph
 	node []interface{}
+	// name stores names of the nodes, empty for the nodes without a name
+	name []string
 	// edge describes function inputs in the graph:
 	// inputs for node[i] which takes n inputs: edge[i][0], ..., edge[i][n]
 	edge [][]int
+	// ports describe the values passed to the nodes,
+	// ports[i][k] refers to a value or all values of the node edge[i][k]
+	ports [][]Port
+	// arguments are indices of the nodes passing on the declared inputs of the graph
+	arguments []int
+	// outputs lists values returned by the compiled graph, nil if not declared
+	outputs []Port
+	// memoized stores functions caching results of the nodes, nil for the nodes which are not memoized
+	memoized []interface{}
 	// function to use to chain functions
 	chain func(steps ...interface{}) (interface{}, error)
 	// function to use to stack functions
@@ -31,6 +55,39 @@ func (g *Graph) ForEachNode(callback func(int, []int)) {
 	}
 }
 
+// Ports returns the values passed to the node at index idx,
+// the nodes they come from are the same as returned by Inputs
+func (g *Graph) Ports(idx int) []Port {
+	return g.ports[idx]
+}
+
+// Arguments returns indices of the nodes passing on the declared inputs of the graph,
+// every such node takes a single argument and returns it as is
+func (g *Graph) Arguments() []int {
+	return g.arguments
+}
+
+func (g *Graph) isArgument(idx int) bool {
+	for _, i := range g.arguments {
+		if i == idx {
+			return true
+		}
+	}
+	return false
+}
+
+// Outputs returns values which the compiled graph returns,
+// nil means the outputs were not declared
+func (g *Graph) Outputs() []Port {
+	return g.outputs
+}
+
+// Memoized returns the function caching results of the node, see Node.Memoize,
+// nil if the node is not memoized
+func (g *Graph) Memoized(idx int) interface{} {
+	return g.memoized[idx]
+}
+
 // get corresponding nodes for given indices
 func (g *Graph) Nodes(indices []int) []interface{} {
 	nodes := make([]interface{}, 0, len(indices))
@@ -40,21 +97,145 @@ func (g *Graph) Nodes(indices []int) []interface{} {
 	return nodes
 }
 
-// insert returns an index of the inserted function
-func (g *Graph) insert(fn interface{}) (int, error) {
+// insert returns an index of the inserted function, the nodes without a name are inserted only once.
+// The step identifies the function in errors.
+func (g *Graph) insert(fn interface{}, step Step) (int, error) {
 	fnType := reflect.TypeOf(fn)
-	if fnType.Kind() != reflect.Func {
-		return 0, fmt.Errorf("not a function: %v", fnType)
+	if fnType == nil || fnType.Kind() != reflect.Func {
+		return 0, &NotAFunctionError{Step: step, Type: fnType}
 	}
 	// search for the fn in the list nodes
 	for i, nodeFn := range g.node {
-		if reflect.ValueOf(fn) == reflect.ValueOf(nodeFn) {
+		if g.name[i] == "" && sameFunc(fn, nodeFn) {
 			return i, nil
 		}
 	}
 	// insert if we failed to find it
+	return g.append("", fn), nil
+}
+
+// insertNamed returns an index of the inserted function, names must be unique
+func (g *Graph) insertNamed(name string, fn interface{}, step Step) (int, error) {
+	fnType := reflect.TypeOf(fn)
+	if fnType == nil || fnType.Kind() != reflect.Func {
+		return 0, &NotAFunctionError{Step: step, Type: fnType}
+	}
+	if i, ok := g.lookup(name); ok {
+		return 0, fmt.Errorf("duplicate node name %q, already used by %s", name, g.describe(i))
+	}
+	return g.append(name, fn), nil
+}
+
+func (g *Graph) append(name string, fn interface{}) int {
 	g.node = append(g.node, fn)
+	g.name = append(g.name, name)
+	g.memoized = append(g.memoized, nil)
 	// align edge array so that indices match
 	g.edge = append(g.edge, make([]int, 0))
-	return len(g.node) - 1, nil
+	g.ports = append(g.ports, make([]Port, 0))
+	return len(g.node) - 1
+}
+
+// connect passes values referred to by the port to the node at index i
+func (g *Graph) connect(i int, port Port) {
+	g.edge[i] = append(g.edge[i], port.Node)
+	g.ports[i] = append(g.ports[i], port)
+}
+
+// sameFunc reports whether the functions are the same value
+func sameFunc(a, b interface{}) bool {
+	return reflect.ValueOf(a) == reflect.ValueOf(b)
+}
+
+// lookupFunc returns an index of the node with the function: the unnamed one if any,
+// otherwise the only named one. A function added under several names is ambiguous.
+func (g *Graph) lookupFunc(fn interface{}) (int, bool, error) {
+	named := make([]int, 0)
+	for i, nodeFn := range g.node {
+		if g.isArgument(i) || !sameFunc(fn, nodeFn) {
+			continue
+		}
+		if g.name[i] == "" {
+			return i, true, nil
+		}
+		named = append(named, i)
+	}
+	switch len(named) {
+	case 0:
+		return 0, false, nil
+	case 1:
+		return named[0], true, nil
+	}
+	names := make([]string, 0, len(named))
+	for _, i := range named {
+		names = append(names, strconv.Quote(g.name[i]))
+	}
+	return 0, false, fmt.Errorf(
+		"function %s is added as nodes %s, refer to one of them by name",
+		funcinfo.Describe(fn), strings.Join(names, ", "),
+	)
+}
+
+// lookup returns an index of the node with the given name
+func (g *Graph) lookup(name string) (int, bool) {
+	for i, nodeName := range g.name {
+		if nodeName == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// resolve returns the port the given function, node name or Ref refers to,
+// functions which are not in the graph yet are inserted if insert is set.
+// The step identifies the reference in errors.
+func (g *Graph) resolve(ref interface{}, insert bool, step Step) (Port, error) {
+	switch r := ref.(type) {
+	case string:
+		i, ok := g.lookup(r)
+		if !ok {
+			return Port{}, fmt.Errorf("unknown node %q", r)
+		}
+		return Port{Node: i, Index: AllValues}, nil
+	case Ref:
+		port, err := g.resolve(r.node, insert, step)
+		if err != nil {
+			return Port{}, err
+		}
+		if numOut := reflect.TypeOf(g.node[port.Node]).NumOut(); r.index < 0 || r.index >= numOut {
+			return Port{}, fmt.Errorf("node %s returns %d values, no value at index %d", g.describe(port.Node), numOut, r.index)
+		}
+		port.Index = r.index
+		return port, nil
+	default:
+		i, ok, err := g.lookupFunc(ref)
+		if err != nil {
+			return Port{}, err
+		}
+		if !ok {
+			if !insert {
+				return Port{}, fmt.Errorf("unknown node %s", funcinfo.Describe(ref))
+			}
+			if i, err = g.insert(ref, step); err != nil {
+				return Port{}, err
+			}
+		}
+		return Port{Node: i, Index: AllValues}, nil
+	}
+}
+
+// Name returns the name of the node, empty if the node was added without a name
+func (g *Graph) Name(idx int) string {
+	return g.name[idx]
+}
+
+// describe returns the node name if any, function name and location
+func (g *Graph) describe(idx int) string {
+	if g.isArgument(idx) {
+		return fmt.Sprintf("input %q %v", g.name[idx], reflect.TypeOf(g.node[idx]).In(0))
+	}
+	if g.name[idx] != "" {
+		return fmt.Sprintf("%q %s", g.name[idx], funcinfo.Describe(g.node[idx]))
+	}
+	return funcinfo.Describe(g.node[idx])
 }
@@ -0,0 +1,160 @@
+package builder
+
+import (
+	"container/list"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// KeyFunc returns a comparable key identifying the arguments of a memoized node,
+// the context taken first is not passed
+type KeyFunc func(args []interface{}) interface{}
+
+// memoization is requested for a node with Node.Memoize
+type memoization struct {
+	size int
+	key  KeyFunc
+}
+
+// Memoize caches up to size results of the node by the values of its arguments,
+// the least recently used ones are evicted. The arguments themselves are the key unless
+// the key function is given, which is needed for the arguments of non-comparable types.
+// Calls with a key holding non-comparable values, like a slice passed as interface{},
+// or values not equal to themselves, like NaN, are not cached, neither are the results with an error.
+// A context taken first is not a part of the key. The cache is shared by all the functions compiled from the graph.
+func (f *Node) Memoize(size int, key KeyFunc) {
+	f.memo = &memoization{size: size, key: key}
+}
+
+// memoize returns a function of the same type as fn caching its results
+func memoize(fn interface{}, m memoization) (interface{}, error) {
+	if m.size < 1 {
+		return nil, fmt.Errorf("memoized node must cache at least one result, got size %d", m.size)
+	}
+	fnType := reflect.TypeOf(fn)
+	skip := 0
+	if fnType.NumIn() > 0 && fnType.In(0) == contextInterface {
+		skip = 1
+	}
+	key := m.key
+	if key == nil {
+		for i := skip; i < fnType.NumIn(); i++ {
+			if !fnType.In(i).Comparable() {
+				return nil, fmt.Errorf(
+					"argument #%d of type %v is not comparable, memoize the node with a key function",
+					i, fnType.In(i),
+				)
+			}
+		}
+		key = argumentsKey(fnType.NumIn() - skip)
+	}
+	withError := fnType.NumOut() > 0 && fnType.Out(fnType.NumOut()-1).Implements(errorInterface)
+
+	cache := newLRU(m.size)
+	call := reflect.ValueOf(fn).Call
+	return reflect.MakeFunc(fnType, func(args []reflect.Value) []reflect.Value {
+		values := make([]interface{}, 0, len(args)-skip)
+		for _, arg := range args[skip:] {
+			values = append(values, arg.Interface())
+		}
+		k := key(values)
+		if !usableKey(k) {
+			return call(args)
+		}
+		if results, ok := cache.get(k); ok {
+			return results
+		}
+		results := call(args)
+		if withError && failed(results[len(results)-1]) {
+			return results
+		}
+		cache.put(k, results)
+		return results
+	}).Interface(), nil
+}
+
+// usableKey reports whether the key can be found in a map, which is not the case for a slice
+// passed as interface{} or NaN
+func usableKey(key interface{}) bool {
+	if key == nil {
+		return true
+	}
+	return reflect.ValueOf(key).Comparable() && key == key
+}
+
+// failed reports whether the value of a type implementing error holds an error,
+// only nil pointers, interfaces and the like hold none
+func failed(err reflect.Value) bool {
+	switch err.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return !err.IsNil()
+	}
+	return true
+}
+
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// argumentsKey returns a key function which puts count arguments into an array
+func argumentsKey(count int) KeyFunc {
+	if count == 1 {
+		return func(args []interface{}) interface{} {
+			return args[0]
+		}
+	}
+	arrayType := reflect.ArrayOf(count, interfaceType)
+	return func(args []interface{}) interface{} {
+		key := reflect.New(arrayType).Elem()
+		for i := range args {
+			if args[i] != nil {
+				key.Index(i).Set(reflect.ValueOf(args[i]))
+			}
+		}
+		return key.Interface()
+	}
+}
+
+// lru keeps the results of at most size calls, safe for concurrent use
+type lru struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[interface{}]*list.Element
+}
+
+type lruEntry struct {
+	key     interface{}
+	results []reflect.Value
+}
+
+func newLRU(size int) *lru {
+	return &lru{size: size, order: list.New(), entries: make(map[interface{}]*list.Element)}
+}
+
+func (c *lru) get(key interface{}) ([]reflect.Value, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry).results, true
+}
+
+func (c *lru) put(key interface{}, results []reflect.Value) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*lruEntry).results = results
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, results: results})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
@@ -0,0 +1,101 @@
+package builder
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// cached returns the first value cached for the key, nil if there is none
+func cached(c *lru, key interface{}) interface{} {
+	results, ok := c.get(key)
+	if !ok {
+		return nil
+	}
+	return results[0].Interface()
+}
+
+func TestLRU_Eviction(t *testing.T) {
+	c := newLRU(2)
+	c.put("a", []reflect.Value{reflect.ValueOf(1)})
+	c.put("b", []reflect.Value{reflect.ValueOf(2)})
+	// a is used, so b is the least recently used one
+	assert.Equal(t, 1, cached(c, "a"))
+	c.put("c", []reflect.Value{reflect.ValueOf(3)})
+
+	assert.Nil(t, cached(c, "b"))
+	assert.Equal(t, 1, cached(c, "a"))
+	assert.Equal(t, 3, cached(c, "c"))
+	assert.Equal(t, 2, c.order.Len())
+	assert.Len(t, c.entries, 2)
+}
+
+func TestLRU_Update(t *testing.T) {
+	c := newLRU(2)
+	c.put("a", []reflect.Value{reflect.ValueOf(1)})
+	c.put("b", []reflect.Value{reflect.ValueOf(2)})
+	// updating a makes it the most recently used one
+	c.put("a", []reflect.Value{reflect.ValueOf(10)})
+	c.put("c", []reflect.Value{reflect.ValueOf(3)})
+
+	assert.Equal(t, 10, cached(c, "a"))
+	assert.Nil(t, cached(c, "b"))
+	assert.Equal(t, 3, cached(c, "c"))
+	assert.Equal(t, 2, c.order.Len())
+}
+
+func TestArgumentsKey(t *testing.T) {
+	assert.Equal(t, [0]interface{}{}, argumentsKey(0)(nil))
+	assert.Equal(t, 1, argumentsKey(1)([]interface{}{1}))
+	assert.Nil(t, argumentsKey(1)([]interface{}{nil}))
+
+	key := argumentsKey(3)
+	assert.Equal(t, [3]interface{}{1, "a", nil}, key([]interface{}{1, "a", nil}))
+	assert.Equal(t, key([]interface{}{1, "a", nil}), key([]interface{}{1, "a", nil}))
+	assert.NotEqual(t, key([]interface{}{1, "a", nil}), key([]interface{}{1, "b", nil}))
+}
+
+type valueError struct{}
+
+func (valueError) Error() string { return "value error" }
+
+func TestMemoize_ValueError(t *testing.T) {
+	calls := 0
+	fn, err := memoize(func(a int) (int, valueError) {
+		calls++
+		return a, valueError{}
+	}, memoization{size: 2})
+	assert.NoError(t, err)
+	f := fn.(func(int) (int, valueError))
+	f(1)
+	f(1)
+	// an error value of a non-pointer type is never nil, so the results are not cached
+	assert.Equal(t, 2, calls)
+}
+
+func TestMemoize_NaN(t *testing.T) {
+	calls := 0
+	fn, err := memoize(func(a float64) float64 {
+		calls++
+		return a
+	}, memoization{size: 2})
+	assert.NoError(t, err)
+	f := fn.(func(float64) float64)
+	f(math.NaN())
+	f(math.NaN())
+	f(1)
+	f(1)
+	assert.Equal(t, 3, calls)
+}
+
+func TestUsableKey(t *testing.T) {
+	assert.True(t, usableKey(nil))
+	assert.True(t, usableKey(1))
+	assert.True(t, usableKey([2]interface{}{1, "a"}))
+	assert.False(t, usableKey([]int{1}))
+	assert.False(t, usableKey([1]interface{}{[]int{1}}))
+	assert.False(t, usableKey(math.NaN()))
+	assert.False(t, usableKey([2]interface{}{1, math.NaN()}))
+}
@@ -0,0 +1,131 @@
+package builder
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/grihabor/gush/internal/dag"
+	"github.com/grihabor/gush/internal/funcinfo"
+)
+
+// RenderOptions configure DOT and Mermaid output of the graph
+type RenderOptions struct {
+	// Layers groups nodes into the layers the graph is compiled into
+	Layers bool
+}
+
+// edge describes values passed from the node at index from to the node at index to
+type edge struct {
+	from, to int
+	types    []reflect.Type
+}
+
+// nodeLabel returns the short function name and its signature
+func (g *Graph) nodeLabel(idx int) (string, string) {
+	fn := g.node[idx]
+	if g.isArgument(idx) {
+		return fmt.Sprintf("%s (input)", g.name[idx]), reflect.TypeOf(fn).In(0).String()
+	}
+	name := funcinfo.Name(fn)
+	name = name[strings.LastIndex(name, "/")+1:]
+	if g.name[idx] != "" {
+		name = fmt.Sprintf("%s (%s)", g.name[idx], name)
+	}
+	return name, reflect.TypeOf(fn).String()
+}
+
+func (g *Graph) edges() []edge {
+	result := make([]edge, 0)
+	for to, inputs := range g.edge {
+		if len(inputs) == 0 {
+			continue
+		}
+		args, counts, err := g.params(to)
+		for i, from := range inputs {
+			e := edge{from: from, to: to}
+			// the graph is validated during build, so args are only missing for hand-made graphs
+			if err == nil {
+				e.types, args = args[:counts[i]], args[counts[i]:]
+			}
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+func typeNames(types []reflect.Type) string {
+	names := make([]string, 0, len(types))
+	for _, typ := range types {
+		names = append(names, typ.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+func (g *Graph) layers(opts RenderOptions) [][]int {
+	if opts.Layers {
+		layers, err := dag.Layers(g.NodeCount(), g.Inputs)
+		if err == nil {
+			return layers
+		}
+	}
+	all := make([]int, 0, g.NodeCount())
+	for i := range g.node {
+		all = append(all, i)
+	}
+	return [][]int{all}
+}
+
+// DOT renders the graph in Graphviz DOT format
+func (g *Graph) DOT(opts RenderOptions) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace
+	var b strings.Builder
+	b.WriteString("digraph gush {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box];\n")
+	for layerIndex, indices := range g.layers(opts) {
+		indent := "\t"
+		if opts.Layers {
+			fmt.Fprintf(&b, "\tsubgraph cluster_layer%d {\n", layerIndex)
+			fmt.Fprintf(&b, "\t\tlabel=\"layer %d\";\n", layerIndex)
+			indent = "\t\t"
+		}
+		for _, idx := range indices {
+			name, signature := g.nodeLabel(idx)
+			fmt.Fprintf(&b, "%sn%d [label=\"%s\\n%s\"];\n", indent, idx, escape(name), escape(signature))
+		}
+		if opts.Layers {
+			b.WriteString("\t}\n")
+		}
+	}
+	for _, e := range g.edges() {
+		fmt.Fprintf(&b, "\tn%d -> n%d [label=\"%s\"];\n", e.from, e.to, escape(typeNames(e.types)))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the graph as Mermaid flowchart
+func (g *Graph) Mermaid(opts RenderOptions) string {
+	escape := strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;").Replace
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for layerIndex, indices := range g.layers(opts) {
+		indent := "\t"
+		if opts.Layers {
+			fmt.Fprintf(&b, "\tsubgraph layer%d [\"layer %d\"]\n", layerIndex, layerIndex)
+			indent = "\t\t"
+		}
+		for _, idx := range indices {
+			name, signature := g.nodeLabel(idx)
+			fmt.Fprintf(&b, "%sn%d[\"%s<br/>%s\"]\n", indent, idx, escape(name), escape(signature))
+		}
+		if opts.Layers {
+			b.WriteString("\tend\n")
+		}
+	}
+	for _, e := range g.edges() {
+		fmt.Fprintf(&b, "\tn%d -->|\"%s\"| n%d\n", e.from, escape(typeNames(e.types)), e.to)
+	}
+	return b.String()
+}
@@ -0,0 +1,57 @@
+package builder
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func double(a int) int { return a * 2 }
+
+func halve(a int) int { return a / 2 }
+
+func renderGraph(t *testing.T) *Graph {
+	gb := NewGraphBuilder()
+	gb.Node(halve).Inputs(double)
+	gb.Node(strconv.Itoa).Inputs(halve)
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return g
+}
+
+func TestGraph_DOT(t *testing.T) {
+	expected := `digraph gush {
+	rankdir=LR;
+	node [shape=box];
+	subgraph cluster_layer0 {
+		label="layer 0";
+		n1 [label="builder.double\nfunc(int) int"];
+	}
+	subgraph cluster_layer1 {
+		label="layer 1";
+		n0 [label="builder.halve\nfunc(int) int"];
+	}
+	subgraph cluster_layer2 {
+		label="layer 2";
+		n2 [label="strconv.Itoa\nfunc(int) string"];
+	}
+	n1 -> n0 [label="int"];
+	n0 -> n2 [label="int"];
+}
+`
+	assert.Equal(t, expected, renderGraph(t).DOT(RenderOptions{Layers: true}))
+}
+
+func TestGraph_Mermaid(t *testing.T) {
+	expected := `flowchart LR
+	n0["builder.halve<br/>func(int) int"]
+	n1["builder.double<br/>func(int) int"]
+	n2["strconv.Itoa<br/>func(int) string"]
+	n1 -->|"int"| n0
+	n0 -->|"int"| n2
+`
+	assert.Equal(t, expected, renderGraph(t).Mermaid(RenderOptions{}))
+}
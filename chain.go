@@ -5,22 +5,17 @@ import (
 	"reflect"
 )
 
-func canChain(fn1 reflect.Type, fn2 reflect.Type) error {
-	if fn1.NumOut() != fn2.NumIn() {
-		return fmt.Errorf(
-			"first function returns %d arguments but second function takes %d arguments",
-			fn1.NumOut(), fn2.NumIn(),
-		)
+// canChain checks that outputs of fn1 can be passed to fn2, which is the given step
+func canChain(step Step, fn1 reflect.Type, fn2 reflect.Type) error {
+	outTypes, err := out(fn1)
+	if err != nil {
+		return err
 	}
-
-	for i := 0; i < fn1.NumOut(); i++ {
-		outKind := fn1.Out(i).Kind()
-		inKind := fn2.In(i).Kind()
-		if outKind != inKind {
-			return fmt.Errorf("arg #%d: %s != %s", i, outKind, inKind)
-		}
+	inTypes, err := in(fn2)
+	if err != nil {
+		return err
 	}
-	return nil
+	return canPass(step, fn2, outTypes, inTypes)
 }
 
 func CanChain(steps ...interface{}) error {
@@ -28,9 +23,12 @@ func CanChain(steps ...interface{}) error {
 	if err := allFunctions(fn); err != nil {
 		return fmt.Errorf("can't chain non functions: %w", err)
 	}
+	if err := nonNil(steps); err != nil {
+		return fmt.Errorf("can't chain nil functions: %w", err)
+	}
 	for i := 0; i < len(steps)-1; i++ {
 		idx1, idx2 := i, i+1
-		err := canChain(fn[idx1], fn[idx2])
+		err := canChain(Step{Index: idx2}, fn[idx1], fn[idx2])
 		if err != nil {
 			return fmt.Errorf(
 				"failed to chain %v at index %d and %v at index %d: %w",
@@ -59,6 +57,18 @@ func (u AllArgs) Chain(functions ...interface{}) (interface{}, error) {
 	return SafeChain(functions...)
 }
 
+func (u AllArgs) In(fn reflect.Type) ([]reflect.Type, error) {
+	return in(fn)
+}
+
+func (u AllArgs) Out(fn reflect.Type) ([]reflect.Type, error) {
+	return out(fn)
+}
+
+func (u AllArgs) Lift(fn interface{}) (interface{}, error) {
+	return fn, nil
+}
+
 func SafeChain(steps ...interface{}) (interface{}, error) {
 	err := CanChain(steps...)
 	if err != nil {
@@ -1,6 +1,8 @@
 package compose
 
 import (
+	"bytes"
+	"io"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -43,3 +45,39 @@ func TestChain(t *testing.T) {
 	result := fn.(func(int, int) int)(6, 3)
 	assert.Equal(t, 2, result)
 }
+
+func TestCanChain_DifferentStructs(t *testing.T) {
+	type first struct{ A int }
+	type second struct{ A int }
+	err := CanChain(
+		func() first { return first{} },
+		func(second) {},
+	)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "compose.first is not assignable to compose.second")
+}
+
+func TestCanChain_DifferentSlices(t *testing.T) {
+	err := CanChain(
+		func() []string { return nil },
+		func([]int) {},
+	)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "[]string is not assignable to []int")
+}
+
+func TestChain_Interface(t *testing.T) {
+	fn, err := SafeChain(
+		func(s string) *bytes.Buffer { return bytes.NewBufferString(s) },
+		func(r io.Reader) (string, error) {
+			data, err := io.ReadAll(r)
+			return string(data), err
+		},
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	result, err := fn.(func(string) (string, error))("gush")
+	assert.NoError(t, err)
+	assert.Equal(t, "gush", result)
+}
@@ -0,0 +1,147 @@
+// Command gush generates Go source computing a graph without reflection.
+//
+// The graph is returned by a function of the package in the current directory,
+// e.g. func NewGraph() *builder.Graph, so that the command is used with go:generate:
+//
+//	//go:generate go run github.com/grihabor/gush/cmd/gush -graph NewGraph -ops LastArgError -name Compute
+//
+// The generated file is excluded from builds with the "gush" tag, which the command uses
+// to load the package, so a stale generated file doesn't prevent generating it again.
+// A stub with the same signature, <output>_stub.go, is built with the tag instead,
+// so the package keeps compiling when it calls the generated function.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+const tag = "gush"
+
+var program = template.Must(template.New("main").Parse(`package main
+
+import (
+	"fmt"
+	"os"
+
+	compose "github.com/grihabor/gush"
+	target {{printf "%q" .Path}}
+)
+
+func main() {
+	g, opts := target.{{.Graph}}(), compose.GenerateOptions{
+		Package:     {{printf "%q" .Package}},
+		PackagePath: {{printf "%q" .Path}},
+		Name:        {{printf "%q" .Name}},
+		BuildTag:    {{printf "%q" .Tag}},
+	}
+	source, err := compose.Generate(g, compose.{{.Ops}}{}, opts)
+	if err != nil {
+		fail(err)
+	}
+	stub, err := compose.GenerateStub(g, compose.{{.Ops}}{}, opts)
+	if err != nil {
+		fail(err)
+	}
+	if err := os.WriteFile({{printf "%q" .Output}}, source, 0o644); err != nil {
+		fail(err)
+	}
+	if err := os.WriteFile({{printf "%q" .Stub}}, stub, 0o644); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+`))
+
+type params struct {
+	Path, Package, Graph, Ops, Name, Tag string
+	// Output and Stub are absolute paths of the generated files
+	Output, Stub string
+}
+
+func main() {
+	graph := flag.String("graph", "", "function of the package returning the graph")
+	ops := flag.String("ops", "AllArgs", "how the graph is compiled: AllArgs, LastArgError or ContextArgs")
+	name := flag.String("name", "", "name of the generated function")
+	output := flag.String("o", "", "output file, <name>_gush.go in lower case by default")
+	dir := flag.String("dir", ".", "directory of the package")
+	flag.Parse()
+	if *graph == "" || *name == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *output == "" {
+		*output = strings.ToLower(*name) + "_gush.go"
+	}
+	if err := run(*dir, *output, params{Graph: *graph, Ops: *ops, Name: *name, Tag: tag}); err != nil {
+		fmt.Fprintf(os.Stderr, "gush: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, output string, p params) error {
+	for _, ident := range []string{p.Graph, p.Ops} {
+		if ident == "" || !unicode.IsUpper([]rune(ident)[0]) {
+			return fmt.Errorf("%q must be an exported identifier", ident)
+		}
+	}
+	listed, err := goCommand(dir, "list", "-tags", tag, "-f", "{{.ImportPath}} {{.Name}}", ".")
+	if err != nil {
+		return fmt.Errorf("failed to load package: %w", err)
+	}
+	fields := strings.Fields(string(listed))
+	if len(fields) != 2 {
+		return fmt.Errorf("unexpected go list output %q", listed)
+	}
+	p.Path, p.Package = fields[0], fields[1]
+	if p.Package == "main" {
+		return fmt.Errorf("package main can't be imported, move the graph to another package")
+	}
+	if p.Output, err = filepath.Abs(filepath.Join(dir, output)); err != nil {
+		return err
+	}
+	p.Stub = strings.TrimSuffix(p.Output, ".go") + "_stub.go"
+
+	// the program must be inside the module to import the package,
+	// directories starting with _ are ignored by the go tool
+	tmp, err := os.MkdirTemp(dir, "_gush")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	var buf bytes.Buffer
+	if err := program.Execute(&buf, p); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "main.go"), buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	if _, err := goCommand(dir, "run", "-tags", tag, "./"+filepath.Base(tmp)); err != nil {
+		return fmt.Errorf("failed to generate: %w", err)
+	}
+	return nil
+}
+
+// goCommand runs the go tool in the directory and returns its output
+func goCommand(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go %s: %w\n%s", args[0], err, stderr.String())
+	}
+	return out, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const calc = `package calc
+
+import (
+	"reflect"
+	"strconv"
+
+	"github.com/grihabor/gush/builder"
+)
+
+func NewGraph() *builder.Graph {
+	gb := builder.NewGraphBuilder()
+	gb.Input("s", reflect.TypeOf(""))
+	gb.Node(strconv.Atoi).Inputs("s")
+	g, err := gb.SafeBuild()
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+`
+
+// TestRun generates the graph again after the package starts calling the generated function
+func TestRun(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil || testing.Short() {
+		t.Skip("go tool is needed to generate the graph")
+	}
+	root, err := filepath.Abs("../..")
+	assert.NoError(t, err)
+	sum, err := os.ReadFile(filepath.Join(root, "go.sum"))
+	assert.NoError(t, err)
+	t.Setenv("GOWORK", "off")
+	t.Setenv("GOFLAGS", "-mod=mod")
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/calc\n\ngo 1.21\n\nrequire github.com/grihabor/gush v0.0.0\n\n" +
+			"replace github.com/grihabor/gush => " + root + "\n",
+		"go.sum":  string(sum),
+		"calc.go": calc,
+	}
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	p := params{Graph: "NewGraph", Ops: "LastArgError", Name: "Parse", Tag: tag}
+	if !assert.NoError(t, run(dir, "parse_gush.go", p)) {
+		return
+	}
+	assert.FileExists(t, filepath.Join(dir, "parse_gush.go"))
+	assert.FileExists(t, filepath.Join(dir, "parse_gush_stub.go"))
+
+	use := "package calc\n\nfunc Double(s string) (int, error) {\n\tn, err := Parse(s)\n\treturn 2 * n, err\n}\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "use.go"), []byte(use), 0o644))
+	assert.NoError(t, run(dir, "parse_gush.go", p))
+
+	for _, args := range [][]string{{"vet", "."}, {"vet", "-tags", tag, "."}} {
+		_, err := goCommand(dir, args...)
+		assert.NoError(t, err)
+	}
+}
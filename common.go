@@ -36,3 +36,51 @@ func out(fn reflect.Type) ([]reflect.Type, error) {
 	}
 	return result, nil
 }
+
+// canAssign checks that a value of type from can be passed as arg #i of type to
+// following the Go assignability rules
+func canAssign(step Step, i int, from reflect.Type, to reflect.Type) error {
+	if !from.AssignableTo(to) {
+		return &TypeMismatchError{Step: step, Arg: i, Given: from, Expected: to}
+	}
+	return nil
+}
+
+// canPass checks that values of types given can be passed to the step function fn taking args
+func canPass(step Step, fn reflect.Type, given []reflect.Type, args []reflect.Type) error {
+	if len(given) != len(args) {
+		return &ArityMismatchError{Step: step, Function: fn, Given: len(given), Expected: len(args)}
+	}
+	for i := range args {
+		if err := canAssign(step, i, given[i], args[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nonNil checks that none of the given functions is nil
+func nonNil(steps []interface{}) error {
+	for i, step := range steps {
+		if step == nil || reflect.ValueOf(step).IsNil() {
+			return &NotAFunctionError{Step: Step{Index: i}, Type: reflect.TypeOf(step), Nil: true}
+		}
+	}
+	return nil
+}
+
+// reuse returns a slice of n values, it reuses the buffer if it is large enough
+func reuse(buffer []reflect.Value, n int) []reflect.Value {
+	if cap(buffer) >= n {
+		return buffer[:n]
+	}
+	return make([]reflect.Value, n)
+}
+
+// larger returns the buffer with the greater capacity
+func larger(a, b []reflect.Value) []reflect.Value {
+	if cap(b) > cap(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,258 @@
+package compose
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var contextInterface = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+func takesContext(fn reflect.Type) bool {
+	return fn.NumIn() > 0 && fn.In(0) == contextInterface
+}
+
+// inWithoutContext returns input types of the function except for the leading context
+func inWithoutContext(fn reflect.Type) ([]reflect.Type, error) {
+	result, err := in(fn)
+	if err != nil {
+		return nil, err
+	}
+	if takesContext(fn) {
+		return result[1:], nil
+	}
+	return result, nil
+}
+
+// callWithContext returns a call which passes the context to the function if it takes one
+func callWithContext(step interface{}) func(ctx reflect.Value, args []reflect.Value) []reflect.Value {
+	call := reflect.ValueOf(step).Call
+	if !takesContext(reflect.TypeOf(step)) {
+		return func(_ reflect.Value, args []reflect.Value) []reflect.Value {
+			return call(args)
+		}
+	}
+	return func(ctx reflect.Value, args []reflect.Value) []reflect.Value {
+		buffer := argumentBuffers.Get().(*[]reflect.Value)
+		in := append(append((*buffer)[:0], ctx), args...)
+		results := call(in)
+		for i := range in {
+			in[i] = reflect.Value{}
+		}
+		*buffer = in[:0]
+		argumentBuffers.Put(buffer)
+		return results
+	}
+}
+
+// argumentBuffers keep the slices the arguments are collected in between the calls
+var argumentBuffers = sync.Pool{
+	New: func() interface{} {
+		return new([]reflect.Value)
+	},
+}
+
+// ContextArgs works like LastArgError, but the resulting function takes context.Context
+// as the first argument and passes it to every function which takes context first
+type ContextArgs struct{}
+
+func (c ContextArgs) Stack(functions ...interface{}) (interface{}, error) {
+	return SafeStackContext(functions...)
+}
+
+func (c ContextArgs) Chain(functions ...interface{}) (interface{}, error) {
+	return SafeChainContext(functions...)
+}
+
+func (c ContextArgs) In(fn reflect.Type) ([]reflect.Type, error) {
+	return inWithoutContext(fn)
+}
+
+func (c ContextArgs) Out(fn reflect.Type) ([]reflect.Type, error) {
+	return outWithError(fn)
+}
+
+func (c ContextArgs) Lift(fn interface{}) (interface{}, error) {
+	lifted, err := liftWithError(fn)
+	if err != nil {
+		return nil, err
+	}
+	liftedType := reflect.TypeOf(lifted)
+	inTypes, err := in(liftedType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get input types of %v: %w", liftedType, err)
+	}
+	outTypes, err := out(liftedType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get output types of %v: %w", liftedType, err)
+	}
+	resultFuncType := reflect.FuncOf(append([]reflect.Type{contextInterface}, inTypes...), outTypes, false)
+	call := reflect.ValueOf(lifted).Call
+	return reflect.MakeFunc(resultFuncType, func(args []reflect.Value) []reflect.Value {
+		return call(args[1:])
+	}).Interface(), nil
+}
+
+func CanChainContext(steps ...interface{}) error {
+	fn := types(steps)
+	if err := allFunctions(fn); err != nil {
+		return fmt.Errorf("can't chain non functions: %w", err)
+	}
+	if err := nonNil(steps); err != nil {
+		return fmt.Errorf("can't chain nil functions: %w", err)
+	}
+	for i := 0; i < len(steps)-1; i++ {
+		idx1, idx2 := i, i+1
+		outputs, err := outWithError(fn[idx1])
+		if err != nil {
+			return fmt.Errorf("failed to chain with context %v at index %d: %w", fn[idx1], idx1, err)
+		}
+		inputs, err := inWithoutContext(fn[idx2])
+		if err != nil {
+			return fmt.Errorf("failed to chain with context %v at index %d: %w", fn[idx2], idx2, err)
+		}
+		if err := canPass(Step{Index: idx2}, fn[idx2], outputs, inputs); err != nil {
+			return fmt.Errorf(
+				"failed to chain with context %v at index %d and %v at index %d: %w",
+				fn[idx1], idx1, fn[idx2], idx2, err,
+			)
+		}
+	}
+	return nil
+}
+
+func ChainContext(steps ...interface{}) interface{} {
+	fn, err := SafeChainContext(steps...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+// SafeChainContext chains functions returning error as the last argument like SafeChainWithError.
+// The resulting function takes context.Context first and passes it to every function taking it.
+// The context is checked before every function is called, its error is returned
+// the same way as an error of a function.
+func SafeChainContext(steps ...interface{}) (interface{}, error) {
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("chain with context needs at least one function")
+	}
+	err := CanChainContext(steps...)
+	if err != nil {
+		return nil, fmt.Errorf("given functions can't be chained with context: %w", err)
+	}
+
+	first := reflect.TypeOf(steps[0])
+	last := reflect.TypeOf(steps[len(steps)-1])
+	inFirst, err := inWithoutContext(first)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get input types of the first function %v: %w", first, err)
+	}
+	outLast, err := outWithError(last)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get output types of the last function %v: %w", last, err)
+	}
+	resultFuncType := reflect.FuncOf(
+		append([]reflect.Type{contextInterface}, inFirst...),
+		append(outLast, errorInterface),
+		false,
+	)
+
+	// precompute calls to save time during execution
+	calls := make([]func(ctx reflect.Value, in []reflect.Value) []reflect.Value, 0)
+	for i := 0; i < len(steps); i++ {
+		calls = append(calls, callWithContext(steps[i]))
+	}
+
+	// precompute empty result for the case when err != nil
+	emptyResult := zeros(outLast)
+
+	// build the resulting function
+	return reflect.MakeFunc(resultFuncType, func(args []reflect.Value) []reflect.Value {
+		all := args
+		ctxValue := args[0]
+		ctx, _ := ctxValue.Interface().(context.Context)
+		args = args[1:]
+		var err reflect.Value
+		for _, call := range calls {
+			if ctx != nil && ctx.Err() != nil {
+				err = errorValue(ctx.Err())
+			} else {
+				args = call(ctxValue, args)
+				err = asError(args[len(args)-1])
+			}
+			if err.Interface() != nil {
+				return failure(larger(all, args), emptyResult, err)
+			}
+			args = args[:len(args)-1]
+		}
+		return append(args, err)
+	}).Interface(), nil
+}
+
+func StackContext(steps ...interface{}) interface{} {
+	result, err := SafeStackContext(steps...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return result
+}
+
+// SafeStackContext stacks functions returning error as the last argument like SafeStackWithError.
+// The resulting function takes context.Context first and passes it to every function taking it.
+// Like in SafeStack, a slice is allocated for the results unless they fit into the arguments.
+func SafeStackContext(steps ...interface{}) (interface{}, error) {
+	if err := canStack(steps); err != nil {
+		return nil, err
+	}
+	functions := types(steps)
+	inputTypes, err := mapEach(inWithoutContext, functions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get functions input types: %w", err)
+	}
+	outputTypes, err := mapEach(outWithError, functions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get functions output types: %w", err)
+	}
+	outputFlatten := flatten(outputTypes)
+	result := reflect.FuncOf(
+		append([]reflect.Type{contextInterface}, flatten(inputTypes)...),
+		append(outputFlatten, errorInterface),
+		false,
+	)
+
+	// precompute calls to save time during execution
+	calls := make([]func(ctx reflect.Value, in []reflect.Value) []reflect.Value, 0)
+	for i := 0; i < len(steps); i++ {
+		calls = append(calls, callWithContext(steps[i]))
+	}
+
+	// precompute empty result for the case when err != nil
+	emptyResult := zeros(outputFlatten)
+	overwrite := inPlace(inputTypes, outputTypes, 1, 1)
+
+	return reflect.MakeFunc(result, func(args []reflect.Value) (results []reflect.Value) {
+		ctxValue := args[0]
+		start := 1
+		outputs := args[:0]
+		if !overwrite {
+			outputs = make([]reflect.Value, 0, len(emptyResult)+1)
+		}
+		errs := make([]error, 0)
+		for i, call := range calls {
+			inputs := args[start : start+len(inputTypes[i])]
+			values := call(ctxValue, inputs)
+			if err := errorOf(values[len(values)-1]); err != nil {
+				errs = append(errs, err)
+			}
+			outputs = append(outputs, values[:len(values)-1]...)
+			start += len(inputTypes[i])
+		}
+		if len(errs) == 0 {
+			return append(outputs, noError)
+		}
+		outputs = append(outputs[:0], emptyResult...)
+		return append(outputs, joinErrors(errs))
+	}).Interface(), nil
+}
@@ -0,0 +1,101 @@
+package compose
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grihabor/gush/builder"
+)
+
+type contextKey struct{}
+
+func TestChainContext(t *testing.T) {
+	fn, err := SafeChainContext(
+		strconv.Atoi,
+		func(ctx context.Context, a int) (int, error) {
+			return a * ctx.Value(contextKey{}).(int), nil
+		},
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ctx := context.WithValue(context.Background(), contextKey{}, 3)
+	result, err := fn.(func(context.Context, string) (int, error))(ctx, "7")
+	assert.NoError(t, err)
+	assert.Equal(t, 21, result)
+}
+
+func TestChainContext_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var called bool
+	fn, err := SafeChainContext(
+		func(a int) (int, error) { cancel(); return a, nil },
+		func(a int) (int, error) { called = true; return a, nil },
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = fn.(func(context.Context, int) (int, error))(ctx, 1)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, called)
+}
+
+func TestContext_ConcreteErrorType(t *testing.T) {
+	negative := func(ctx context.Context, a int) (int, *negativeError) {
+		if a < 0 {
+			return 0, &negativeError{value: a}
+		}
+		return a, nil
+	}
+	ctx := context.Background()
+
+	chained := ChainContext(negative, negative).(func(context.Context, int) (int, error))
+	result, err := chained(ctx, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, result)
+	_, err = chained(ctx, -5)
+	assert.EqualError(t, err, "negative -5")
+
+	stacked := StackContext(negative, negative).(func(context.Context, int, int) (int, int, error))
+	a, b, err := stacked(ctx, 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, []int{a, b})
+	a, b, err = stacked(ctx, 1, -2)
+	assert.EqualError(t, err, "negative -2")
+	assert.Equal(t, []int{0, 0}, []int{a, b})
+}
+
+func TestNewGraph_ContextArgs(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	src := func(ctx context.Context, s string) (int, error) { return strconv.Atoi(s) }
+	scale := func(ctx context.Context, a int) (int, error) {
+		return a * ctx.Value(contextKey{}).(int), nil
+	}
+	sum := func(a, b int) (int, error) { return a + b, nil }
+	gb.Node(scale).Inputs(src)
+	gb.Node(sum).Inputs(scale, src)
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	fn, err := SafeCompile(g, ContextArgs{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	fun := fn.(func(context.Context, string) (int, error))
+
+	ctx := context.WithValue(context.Background(), contextKey{}, 3)
+	result, err := fun(ctx, "5")
+	assert.NoError(t, err)
+	assert.Equal(t, 20, result)
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = fun(ctx, "5")
+	assert.ErrorIs(t, err, context.Canceled)
+}
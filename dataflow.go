@@ -0,0 +1,133 @@
+package compose
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+func CompileDataflow(g G, workers int, opts ...Option) interface{} {
+	result, err := SafeCompileDataflow(g, workers, opts...)
+	if err != nil {
+		panic(fmt.Sprintf("%v", err))
+	}
+	return result
+}
+
+// SafeCompileDataflow builds a function with the same signature and results as
+// SafeCompile with AllArgs does, but instead of running the graph layer by layer
+// every node is started as soon as all of its inputs are calculated.
+// At most workers nodes are running at once, zero means no limit.
+// Options are applied to every node and the nodes feeding no declared output
+// are not called like in SafeCompile.
+func SafeCompileDataflow(g G, workers int, opts ...Option) (interface{}, error) {
+	if workers < 0 {
+		return nil, fmt.Errorf("number of workers must not be negative, got %d", workers)
+	}
+	o := newOptions(opts)
+	if o.tracer != nil {
+		return nil, fmt.Errorf("tracing needs context, which dataflow functions don't take")
+	}
+	if o.lazy {
+		return nil, fmt.Errorf("dataflow functions don't support lazy evaluation")
+	}
+	// compile the layered version to validate the graph and get the resulting signature
+	layered, err := SafeCompile(g, AllArgs{})
+	if err != nil {
+		return nil, err
+	}
+	resultFuncType := reflect.TypeOf(layered)
+	p, err := prepare(g, AllArgs{}, o)
+	if err != nil {
+		return nil, err
+	}
+
+	count := g.NodeCount()
+	calls := make([]func(in []reflect.Value) []reflect.Value, 0, count)
+	for i := 0; i < count; i++ {
+		calls = append(calls, reflect.ValueOf(p.fns[i]).Call)
+	}
+
+	// arguments of the resulting function go to the nodes of the first layer
+	argOffsets := make(map[int][2]int)
+	offset := 0
+	for _, idx := range p.layers[0] {
+		next := offset + len(p.inTypes[idx])
+		argOffsets[idx] = [2]int{offset, next}
+		offset = next
+	}
+	outputs := p.outputPorts()
+
+	return reflect.MakeFunc(resultFuncType, func(args []reflect.Value) []reflect.Value {
+		results := make([][]reflect.Value, count)
+		done := make([]chan struct{}, count)
+		for i := range done {
+			done[i] = make(chan struct{})
+		}
+		var semaphore chan struct{}
+		if workers > 0 {
+			semaphore = make(chan struct{}, workers)
+		}
+		// after a panic the nodes left are skipped and the first panic is propagated
+		var (
+			wg        sync.WaitGroup
+			failed    atomic.Bool
+			panicOnce sync.Once
+			panicked  interface{}
+		)
+		wg.Add(count)
+		for i := 0; i < count; i++ {
+			go func(i int) {
+				defer wg.Done()
+				defer close(done[i])
+				if !p.called(i) {
+					return
+				}
+				for _, input := range p.inputs[i] {
+					<-done[input.node]
+				}
+				if failed.Load() {
+					return
+				}
+				if semaphore != nil {
+					semaphore <- struct{}{}
+					defer func() { <-semaphore }()
+				}
+				defer func() {
+					if r := recover(); r != nil {
+						failed.Store(true)
+						panicOnce.Do(func() { panicked = r })
+					}
+				}()
+
+				var inputs []reflect.Value
+				if bounds, ok := argOffsets[i]; ok {
+					inputs = args[bounds[0]:bounds[1]]
+				} else {
+					inputs = collect(results, p.inputs[i])
+				}
+				results[i] = calls[i](inputs)
+			}(i)
+		}
+		wg.Wait()
+		if panicked != nil {
+			panic(panicked)
+		}
+
+		return collect(results, outputs)
+	}).Interface(), nil
+}
+
+// collect returns the values referred to by the ports
+func collect(results [][]reflect.Value, ports []port) []reflect.Value {
+	values := make([]reflect.Value, 0, len(ports))
+	for _, pt := range ports {
+		if pt.index == allValues {
+			values = append(values, results[pt.node]...)
+		} else {
+			values = append(values, results[pt.node][pt.index])
+		}
+	}
+	return values
+}
@@ -0,0 +1,81 @@
+package compose
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grihabor/gush/builder"
+)
+
+func TestCompileDataflow(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	src := func(a int) int { return a }
+	double := func(a int) int { return a * 2 }
+	triple := func(a int) int { return a * 3 }
+	incr := func(a int) int { return a + 1 }
+	sum := func(a, b, c int) int { return a*100 + b*10 + c }
+	gb.Node(double).Inputs(src)
+	gb.Node(triple).Inputs(src)
+	gb.Node(incr).Inputs(double)
+	gb.Node(sum).Inputs(incr, triple, src)
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	fn, err := SafeCompileDataflow(g, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 3*100+3*10+1, fn.(func(int) int)(1))
+}
+
+func TestCompileDataflow_SlowBranch(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	// fast branch must finish while the slow one is still running
+	fastDone := make(chan struct{})
+	slow := func() int { <-fastDone; return 1 }
+	fast := func() int { return 2 }
+	fastNext := func(a int) int { close(fastDone); return a + 1 }
+	sum := func(a, b int) int { return a + b }
+	gb.Node(fastNext).Inputs(fast)
+	gb.Node(sum).Inputs(slow, fastNext)
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	fn, err := SafeCompileDataflow(g, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	result := make(chan int)
+	go func() { result <- fn.(func() int)() }()
+	select {
+	case r := <-result:
+		assert.Equal(t, 4, r)
+	case <-time.After(time.Second):
+		t.Fatal("fast branch was blocked by the slow one")
+	}
+}
+
+func TestCompileDataflow_Panic(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	src := func() int { panic("boom") }
+	gb.Node(func(a int) int { return a }).Inputs(src)
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	fn, err := SafeCompileDataflow(g, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.PanicsWithValue(t, "boom", func() { fn.(func() int)() })
+}
@@ -5,40 +5,67 @@ import (
 	"reflect"
 )
 
-var errorInterface = reflect.TypeOf((*error)(nil)).Elem()
+var (
+	errorInterface = reflect.TypeOf((*error)(nil)).Elem()
+	noError        = reflect.Zero(errorInterface)
+)
 
 func isError(t reflect.Type) bool {
 	return t.Implements(errorInterface)
 }
 
-func canChainWithError(fn1 reflect.Type, fn2 reflect.Type) error {
-	lastArg := fn1.Out(fn1.NumOut() - 1)
-	if !isError(lastArg) {
-		return fmt.Errorf("first function must return error as the last argument")
+// asError converts the value of a type implementing error to the error interface type,
+// nil pointers become nil errors
+func asError(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		if v.IsNil() {
+			return noError
+		}
 	}
+	return v.Convert(errorInterface)
+}
 
-	if fn1.NumOut()-1 != fn2.NumIn() {
-		return fmt.Errorf(
-			"first function returns %d arguments and error but second function takes %d arguments",
-			fn1.NumOut()-1, fn2.NumIn(),
-		)
-	}
+// errorOf returns the error held by the value of a type implementing error,
+// nil pointers are nil errors
+func errorOf(v reflect.Value) error {
+	err, _ := asError(v).Interface().(error)
+	return err
+}
 
-	for i := 0; i < fn2.NumIn(); i++ {
-		outKind := fn1.Out(i).Kind()
-		inKind := fn2.In(i).Kind()
-		if outKind != inKind {
-			return fmt.Errorf("arg #%d: %s != %s", i, outKind, inKind)
-		}
+// withErrorResult returns the function type with error as the last result
+func withErrorResult(fnType reflect.Type) reflect.Type {
+	inTypes, _ := in(fnType)
+	outTypes, _ := out(fnType)
+	outTypes[len(outTypes)-1] = errorInterface
+	return reflect.FuncOf(inTypes, outTypes, fnType.IsVariadic())
+}
+
+// canChainWithError checks that outputs of fn1 except for the error can be passed to fn2,
+// which is the given step
+func canChainWithError(step Step, fn1 reflect.Type, fn2 reflect.Type) error {
+	outTypes, err := outWithError(fn1)
+	if err != nil {
+		return err
 	}
-	return nil
+	inTypes, err := in(fn2)
+	if err != nil {
+		return err
+	}
+	return canPass(step, fn2, outTypes, inTypes)
 }
 
 func CanChainWithError(steps ...interface{}) error {
 	fn := types(steps)
+	if err := allFunctions(fn); err != nil {
+		return fmt.Errorf("can't chain non functions: %w", err)
+	}
+	if err := nonNil(steps); err != nil {
+		return fmt.Errorf("can't chain nil functions: %w", err)
+	}
 	for i := 0; i < len(steps)-1; i++ {
 		idx1, idx2 := i, i+1
-		err := canChainWithError(fn[idx1], fn[idx2])
+		err := canChainWithError(Step{Index: idx2}, fn[idx1], fn[idx2])
 		if err != nil {
 			return fmt.Errorf(
 				"failed to chain with error %v at index %d and %v at index %d: %w",
@@ -49,14 +76,78 @@ func CanChainWithError(steps ...interface{}) error {
 	return nil
 }
 
+// outWithError returns output types of the function except for the last one,
+// which must be an error
+func outWithError(fn reflect.Type) ([]reflect.Type, error) {
+	result, err := out(fn)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 || !isError(result[len(result)-1]) {
+		return nil, fmt.Errorf("function must return error as the last argument, got %v", fn)
+	}
+	return result[:len(result)-1], nil
+}
+
+// zeros returns zero values of the given types
+func zeros(types []reflect.Type) []reflect.Value {
+	result := make([]reflect.Value, 0, len(types))
+	for _, typ := range types {
+		result = append(result, reflect.Zero(typ))
+	}
+	return result
+}
+
+// liftWithError turns a function into one which also returns nil error
+func liftWithError(step interface{}) (interface{}, error) {
+	fn := reflect.TypeOf(step)
+	inTypes, err := in(fn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get input types of %v: %w", fn, err)
+	}
+	outTypes, err := out(fn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get output types of %v: %w", fn, err)
+	}
+	resultFuncType := reflect.FuncOf(inTypes, append(outTypes, errorInterface), false)
+	call := reflect.ValueOf(step).Call
+	return reflect.MakeFunc(resultFuncType, func(args []reflect.Value) []reflect.Value {
+		values := call(args)
+		results := reuse(larger(args, values), len(values)+1)
+		copy(results, values)
+		results[len(values)] = noError
+		return results
+	}).Interface(), nil
+}
+
+// failure returns the zero values followed by the error, reusing the buffer if it is large enough
+func failure(buffer, zeros []reflect.Value, err reflect.Value) []reflect.Value {
+	result := reuse(buffer, len(zeros)+1)
+	copy(result, zeros)
+	result[len(zeros)] = err
+	return result
+}
+
 type LastArgError struct{}
 
 func (r LastArgError) Stack(functions ...interface{}) (interface{}, error) {
-	return SafeStack(functions...)
+	return SafeStackWithError(functions...)
 }
 
 func (r LastArgError) Chain(functions ...interface{}) (interface{}, error) {
-	return SafeStackWithError(functions...)
+	return SafeChainWithError(functions...)
+}
+
+func (r LastArgError) In(fn reflect.Type) ([]reflect.Type, error) {
+	return in(fn)
+}
+
+func (r LastArgError) Out(fn reflect.Type) ([]reflect.Type, error) {
+	return outWithError(fn)
+}
+
+func (r LastArgError) Lift(fn interface{}) (interface{}, error) {
+	return liftWithError(fn)
 }
 
 func ChainWithError(steps ...interface{}) interface{} {
@@ -78,9 +169,8 @@ func SafeChainWithError(steps ...interface{}) (interface{}, error) {
 
 	first := reflect.TypeOf(steps[0])
 	last := reflect.TypeOf(steps[len(steps)-1])
-	lastLastArg := last.Out(last.NumOut() - 1)
-	if !isError(lastLastArg) {
-		return nil, fmt.Errorf("last function must return an error as it's last argument, got %v", last)
+	if _, err := outWithError(last); err != nil {
+		return nil, fmt.Errorf("last function must return an error as it's last argument: %w", err)
 	}
 
 	inFirst, err := in(first)
@@ -100,21 +190,17 @@ func SafeChainWithError(steps ...interface{}) (interface{}, error) {
 	}
 
 	// precompute empty result for the case when err != nil
-	emptyResult := make([]reflect.Value, 0, len(calls))
-	for i := 0; i < last.NumOut()-1; i++ {
-		emptyResult = append(emptyResult, reflect.New(last.Out(i)).Elem())
-	}
+	emptyResult := zeros(outLast[:len(outLast)-1])
 
 	// build the resulting function
 	return reflect.MakeFunc(resultFuncType, func(args []reflect.Value) []reflect.Value {
+		all := args
 		var err reflect.Value
 		for _, call := range calls {
 			args = call(args)
 			err = args[len(args)-1]
-			if err.Interface() != nil {
-				result := make([]reflect.Value, len(emptyResult))
-				copy(result, emptyResult)
-				return append(emptyResult, err)
+			if errorOf(err) != nil {
+				return failure(larger(all, args), emptyResult, err)
 			}
 			args = args[:len(args)-1]
 		}
@@ -59,3 +59,32 @@ func TestChainWithError_PropagateError(t *testing.T) {
 	assert.Error(t, err)
 	assert.EqualError(t, err, "fail")
 }
+
+func TestChainWithError_ConcreteErrorType(t *testing.T) {
+	fn := ChainWithError(
+		func(a int) (int, *negativeError) { return a - 1, nil },
+		func(a int) (int, *negativeError) {
+			if a < 0 {
+				return 0, &negativeError{value: a}
+			}
+			return a, nil
+		},
+	).(func(int) (int, *negativeError))
+
+	result, err := fn(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result)
+
+	result, err = fn(0)
+	assert.EqualError(t, err, "negative -1")
+	assert.Equal(t, 0, result)
+}
+
+func TestCanChainWithError_TypeMismatch(t *testing.T) {
+	err := CanChainWithError(
+		func() ([]string, error) { return nil, nil },
+		func([]int) error { return nil },
+	)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "[]string is not assignable to []int")
+}
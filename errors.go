@@ -0,0 +1,72 @@
+package compose
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/grihabor/gush/internal/errs"
+)
+
+// Errors returned when functions can't be composed,
+// they are reachable with errors.As from the returned errors
+type (
+	Step               = errs.Step
+	NotAFunctionError  = errs.NotAFunctionError
+	ArityMismatchError = errs.ArityMismatchError
+	TypeMismatchError  = errs.TypeMismatchError
+	CycleError         = errs.CycleError
+)
+
+// StepError is returned by a composed function when one of its steps fails, see StepErrors
+type StepError struct {
+	// Step is the failed step
+	Step
+	// Function is the name of the step function, File and Line are its location
+	Function string
+	File     string
+	Line     int
+	// Args are the arguments the step was called with, only recorded with StepArgs
+	Args []interface{}
+	// Err is the error returned by the step
+	Err error
+}
+
+func (e *StepError) Error() string {
+	if e.Node != "" {
+		// node description already contains the function name and location
+		return fmt.Sprintf("%v failed: %v", e.Step, e.Err)
+	}
+	if e.File == "" {
+		return fmt.Sprintf("%v %s failed: %v", e.Step, e.Function, e.Err)
+	}
+	return fmt.Sprintf("%v %s (%s:%d) failed: %v", e.Step, e.Function, filepath.Base(e.File), e.Line, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is returned by a composed function when one of its steps panics, see Recover
+type PanicError struct {
+	// Step is the panicked step
+	Step
+	// Function is the name of the step function
+	Function string
+	// Value is the value passed to panic
+	Value interface{}
+	// Stack is the stack trace of the panicked goroutine
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	if e.Node != "" {
+		return fmt.Sprintf("%v panicked: %v", e.Step, e.Value)
+	}
+	return fmt.Sprintf("%v %s panicked: %v", e.Step, e.Function, e.Value)
+}
+
+// Unwrap returns the panic value if it is an error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
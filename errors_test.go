@@ -0,0 +1,134 @@
+package compose
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanChain_ArityMismatchError(t *testing.T) {
+	err := CanChain(
+		func() (int, int) { return 1, 2 },
+		func(int) int { return 1 },
+	)
+	var arityErr *ArityMismatchError
+	if assert.True(t, errors.As(err, &arityErr)) {
+		assert.Equal(t, 1, arityErr.Index)
+		assert.Equal(t, 2, arityErr.Given)
+		assert.Equal(t, 1, arityErr.Expected)
+		assert.Equal(t, reflect.TypeOf(func(int) int { return 1 }), arityErr.Function)
+	}
+}
+
+func TestCanChain_TypeMismatchError(t *testing.T) {
+	err := CanChain(
+		func() int { return 1 },
+		func(int) string { return "" },
+		func(int) int { return 1 },
+	)
+	var typeErr *TypeMismatchError
+	if assert.True(t, errors.As(err, &typeErr)) {
+		assert.Equal(t, 2, typeErr.Index)
+		assert.Equal(t, 0, typeErr.Arg)
+		assert.Equal(t, reflect.TypeOf(""), typeErr.Given)
+		assert.Equal(t, reflect.TypeOf(0), typeErr.Expected)
+	}
+}
+
+func TestSafeStack_NotAFunctionError(t *testing.T) {
+	_, err := SafeStack(func() int { return 1 }, 42)
+	var notFnErr *NotAFunctionError
+	if assert.True(t, errors.As(err, &notFnErr)) {
+		assert.Equal(t, 1, notFnErr.Index)
+		assert.Equal(t, reflect.TypeOf(42), notFnErr.Type)
+		assert.False(t, notFnErr.Nil)
+	}
+
+	var nilFn func() int
+	_, err = SafeStack(func() int { return 1 }, nilFn)
+	if assert.True(t, errors.As(err, &notFnErr)) {
+		assert.Equal(t, 1, notFnErr.Index)
+		assert.True(t, notFnErr.Nil)
+	}
+}
+
+func TestCanChainWithError_TypeMismatchError(t *testing.T) {
+	err := CanChainWithError(
+		func() (string, error) { return "", nil },
+		func(int) error { return nil },
+	)
+	var typeErr *TypeMismatchError
+	if assert.True(t, errors.As(err, &typeErr)) {
+		assert.Equal(t, 1, typeErr.Index)
+		assert.Equal(t, reflect.TypeOf(""), typeErr.Given)
+	}
+}
+
+func TestSafeCompile_ArityMismatchError(t *testing.T) {
+	g := testGraph{
+		node: []interface{}{
+			func() int { return 1 },
+			func(int, int) int { return 1 },
+		},
+		edge: [][]int{nil, {0}},
+	}
+	_, err := SafeCompile(g, AllArgs{})
+	var arityErr *ArityMismatchError
+	if assert.True(t, errors.As(err, &arityErr)) {
+		assert.Equal(t, 1, arityErr.Index)
+		assert.Contains(t, arityErr.Node, "TestSafeCompile_ArityMismatchError.func2")
+	}
+}
+
+func TestSafeCompile_CycleError(t *testing.T) {
+	g := testGraph{
+		node: []interface{}{double, halve},
+		edge: [][]int{{1}, {0}},
+	}
+	_, err := SafeCompile(g, AllArgs{})
+	var cycleErr *CycleError
+	if assert.True(t, errors.As(err, &cycleErr)) {
+		assert.Equal(t, []int{0, 1, 0}, cycleErr.Nodes)
+		assert.Len(t, cycleErr.Descriptions, 3)
+	}
+}
+
+func TestSafeBuild_Errors(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Node(double).Inputs(halve)
+	gb.Node(halve).Inputs(double)
+	_, err := gb.SafeBuild()
+	var cycleErr *CycleError
+	assert.True(t, errors.As(err, &cycleErr))
+
+	gb = NewGraphBuilder()
+	gb.Node(double)
+	gb.Named("answer", 42)
+	_, err = gb.SafeBuild()
+	var notFnErr *NotAFunctionError
+	if assert.True(t, errors.As(err, &notFnErr)) {
+		assert.Equal(t, reflect.TypeOf(42), notFnErr.Type)
+		assert.Equal(t, Step{Index: 1, Node: `"answer"`}, notFnErr.Step)
+	}
+
+	gb = NewGraphBuilder()
+	gb.Node(double).Inputs(halve, 42)
+	_, err = gb.SafeBuild()
+	if assert.True(t, errors.As(err, &notFnErr)) {
+		assert.Equal(t, Step{Index: 1}, notFnErr.Step)
+		assert.ErrorContains(t, err, "failed to insert input 42 at #1 for node")
+	}
+
+	gb = NewGraphBuilder()
+	gb.Named("src", func() string { return "" })
+	gb.Node(double).Inputs("src")
+	_, err = gb.SafeBuild()
+	var typeErr *TypeMismatchError
+	if assert.True(t, errors.As(err, &typeErr)) {
+		assert.Equal(t, reflect.TypeOf(""), typeErr.Given)
+		assert.Equal(t, reflect.TypeOf(0), typeErr.Expected)
+		assert.Contains(t, typeErr.Node, "double")
+	}
+}
@@ -0,0 +1,651 @@
+package compose
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	gotypes "go/types"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grihabor/gush/internal/funcinfo"
+)
+
+// GenerateOptions describe the source generated by Generate
+type GenerateOptions struct {
+	// Package is the name of the package of the generated file
+	Package string
+	// PackagePath is the import path of the package,
+	// functions and types from it are referred to without qualifier
+	PackagePath string
+	// Name is the name of the generated function
+	Name string
+	// BuildTag excludes the generated file from builds with the tag if set,
+	// so that the graph can be generated again when the file doesn't compile, see GenerateStub
+	BuildTag string
+}
+
+// Generate returns Go source of a function which computes the graph the same way
+// the function compiled with Compile(g, ops) does, but calls the nodes directly.
+// Nodes must be top-level functions, ops must be AllArgs, LastArgError or ContextArgs.
+func Generate(g G, ops Ops, opts GenerateOptions) ([]byte, error) {
+	gen, err := newGenerator(g, ops, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := gen.collect(); err != nil {
+		return nil, err
+	}
+	gen.name()
+	gen.emit()
+	return gen.format()
+}
+
+// GenerateStub returns Go source of a function with the same signature as the one returned
+// by Generate, which panics. The stub is built only with the build tag, so the package
+// using the generated function still compiles while the graph is generated again.
+func GenerateStub(g G, ops Ops, opts GenerateOptions) ([]byte, error) {
+	if opts.BuildTag == "" {
+		return nil, fmt.Errorf("stub needs a build tag to replace the generated function")
+	}
+	gen, err := newGenerator(g, ops, opts)
+	if err != nil {
+		return nil, err
+	}
+	gen.stub = true
+	// only the types of the signature are referred to
+	if gen.withCtx {
+		gen.importPath("context")
+	}
+	paramTypes, resultTypes := gen.signatureTypes()
+	for _, typ := range append(paramTypes, resultTypes...) {
+		if _, err := gen.typeRef(typ); err != nil {
+			return nil, fmt.Errorf("can't generate stub: %w", err)
+		}
+	}
+	gen.name()
+	gen.printf("// %s is replaced by the generated function in builds without the %s tag\n", opts.Name, opts.BuildTag)
+	gen.printf("%s {\n", gen.header())
+	gen.printf("panic(%q)\n}\n", opts.Name+" is generated by gush, build without the "+opts.BuildTag+" tag")
+	return gen.format()
+}
+
+// newGenerator checks the options and prepares the graph
+func newGenerator(g G, ops Ops, opts GenerateOptions) (*generator, error) {
+	gen := &generator{
+		opts:       opts,
+		imports:    make(map[string]string),
+		aliases:    make(map[string]bool),
+		locals:     make(map[string]bool),
+		types:      make(map[reflect.Type]string),
+		isArgument: make(map[int]bool),
+	}
+	switch ops.(type) {
+	case AllArgs:
+	case LastArgError:
+		gen.withError = true
+	case ContextArgs:
+		gen.withError, gen.withCtx = true, true
+	default:
+		return nil, fmt.Errorf("can't generate graph compiled with %T", ops)
+	}
+	if !token.IsIdentifier(opts.Name) || !token.IsIdentifier(opts.Package) {
+		return nil, fmt.Errorf("invalid package name %q or function name %q", opts.Package, opts.Name)
+	}
+	p, err := prepare(g, ops, options{})
+	if err != nil {
+		return nil, err
+	}
+	gen.p = p
+	for _, idx := range p.arguments {
+		gen.isArgument[idx] = true
+	}
+	return gen, nil
+}
+
+// format returns the whole generated file formatted
+func (gen *generator) format() ([]byte, error) {
+	source, err := format.Source(gen.source())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated source: %w", err)
+	}
+	return source, nil
+}
+
+type generator struct {
+	p          *prepared
+	opts       GenerateOptions
+	withError  bool
+	withCtx    bool
+	isArgument map[int]bool
+	// needJoin is set when a layer has several nodes returning errors
+	needJoin bool
+	// stub is set when only the signature is generated, see GenerateStub
+	stub bool
+
+	// imports map import paths to their aliases
+	imports map[string]string
+	aliases map[string]bool
+	// locals are identifiers referred to without qualifier
+	locals map[string]bool
+	// funcs are references to the node functions, types are the rendered value types
+	funcs []string
+	types map[reflect.Type]string
+
+	// values are expressions of the values every node passes on
+	values [][]string
+	params []string
+	// used marks the values passed to other nodes or returned
+	used [][]bool
+
+	body bytes.Buffer
+}
+
+// generatedName matches the names of the generated variables
+var generatedName = regexp.MustCompile(`^(v\d+_\d+|err\d+|in\d+|ctx)$`)
+
+// collect resolves references to all the functions and types used by the generated function
+func (gen *generator) collect() error {
+	p := gen.p
+	if gen.withCtx {
+		gen.importPath("context")
+	}
+	if gen.withError {
+		for _, indices := range p.layers {
+			nodes := 0
+			for _, idx := range indices {
+				if !gen.isArgument[idx] && p.called(idx) {
+					nodes++
+				}
+			}
+			gen.needJoin = gen.needJoin || nodes > 1
+		}
+	}
+	if gen.needJoin {
+		gen.locals[gen.joinErrors()] = true
+		gen.importPath("errors")
+	}
+	for i, fn := range p.fns {
+		if gen.isArgument[i] || !p.called(i) {
+			gen.funcs = append(gen.funcs, "")
+		} else {
+			if memoized(p.g, i) != nil {
+				return fmt.Errorf("can't generate memoized node %s", describeNode(p.g, i))
+			}
+			ref, err := gen.funcRef(fn)
+			if err != nil {
+				return fmt.Errorf("can't generate node %s: %w", describeNode(p.g, i), err)
+			}
+			gen.funcs = append(gen.funcs, ref)
+		}
+		for _, typ := range append(append([]reflect.Type{}, p.inTypes[i]...), p.outTypes[i]...) {
+			if _, err := gen.typeRef(typ); err != nil {
+				return fmt.Errorf("can't generate node %s: %w", describeNode(p.g, i), err)
+			}
+		}
+	}
+	return nil
+}
+
+// funcRef returns the expression referring to a top-level function
+func (gen *generator) funcRef(fn interface{}) (string, error) {
+	name := funcinfo.Name(fn)
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot < 0 {
+		return "", fmt.Errorf("unexpected function name %s", name)
+	}
+	// dots in the last path element are escaped by the runtime
+	path := strings.ReplaceAll(name[:slash+1+dot], "%2e", ".")
+	ident := name[slash+1+dot+1:]
+	if !token.IsIdentifier(ident) {
+		return "", fmt.Errorf("%s is not a top-level function", name)
+	}
+	return gen.qualified(path, ident)
+}
+
+// qualified returns the expression referring to an identifier from the package
+func (gen *generator) qualified(path, ident string) (string, error) {
+	if path == gen.opts.PackagePath {
+		gen.locals[ident] = true
+		return ident, nil
+	}
+	if !token.IsExported(ident) {
+		return "", fmt.Errorf("%s.%s is not exported", path, ident)
+	}
+	if path == "main" {
+		return "", fmt.Errorf("%s.%s can't be imported from package main", path, ident)
+	}
+	return gen.importPath(path) + "." + ident, nil
+}
+
+// importPath imports the package and returns its alias
+func (gen *generator) importPath(path string) string {
+	if alias, ok := gen.imports[path]; ok {
+		return alias
+	}
+	base := path[strings.LastIndex(path, "/")+1:]
+	alias := strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, base)
+	if !token.IsIdentifier(alias) {
+		alias = "pkg_" + alias
+	}
+	for candidate, i := alias, 2; ; i++ {
+		if !gen.aliases[candidate] && !generatedName.MatchString(candidate) {
+			alias = candidate
+			break
+		}
+		candidate = alias + strconv.Itoa(i)
+	}
+	gen.imports[path] = alias
+	gen.aliases[alias] = true
+	return alias
+}
+
+// typeRef returns the expression of the type
+func (gen *generator) typeRef(typ reflect.Type) (string, error) {
+	if ref, ok := gen.types[typ]; ok {
+		return ref, nil
+	}
+	ref, err := gen.renderType(typ)
+	if err != nil {
+		return "", err
+	}
+	gen.types[typ] = ref
+	return ref, nil
+}
+
+func (gen *generator) renderType(typ reflect.Type) (string, error) {
+	if typ.Name() != "" {
+		if strings.Contains(typ.Name(), "[") {
+			return "", fmt.Errorf("generic type %v is not supported", typ)
+		}
+		if typ.PkgPath() == "" {
+			return typ.Name(), nil
+		}
+		return gen.qualified(typ.PkgPath(), typ.Name())
+	}
+	elem := func() (string, error) { return gen.typeRef(typ.Elem()) }
+	switch typ.Kind() {
+	case reflect.Ptr:
+		e, err := elem()
+		return "*" + e, err
+	case reflect.Slice:
+		e, err := elem()
+		return "[]" + e, err
+	case reflect.Array:
+		e, err := elem()
+		return fmt.Sprintf("[%d]%s", typ.Len(), e), err
+	case reflect.Chan:
+		e, err := elem()
+		switch typ.ChanDir() {
+		case reflect.RecvDir:
+			return "<-chan " + e, err
+		case reflect.SendDir:
+			return "chan<- " + e, err
+		}
+		return "chan " + e, err
+	case reflect.Map:
+		k, err := gen.typeRef(typ.Key())
+		if err != nil {
+			return "", err
+		}
+		e, err := elem()
+		return fmt.Sprintf("map[%s]%s", k, e), err
+	case reflect.Func:
+		signature, err := gen.signature(typ)
+		return "func" + signature, err
+	case reflect.Struct:
+		fields := make([]string, 0, typ.NumField())
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			t, err := gen.typeRef(field.Type)
+			if err != nil {
+				return "", err
+			}
+			if !field.Anonymous {
+				t = field.Name + " " + t
+			}
+			if field.Tag != "" {
+				t += " " + strconv.Quote(string(field.Tag))
+			}
+			fields = append(fields, t)
+		}
+		return "struct{ " + strings.Join(fields, "; ") + " }", nil
+	case reflect.Interface:
+		if typ.NumMethod() == 0 {
+			return "interface{}", nil
+		}
+		methods := make([]string, 0, typ.NumMethod())
+		for i := 0; i < typ.NumMethod(); i++ {
+			method := typ.Method(i)
+			signature, err := gen.signature(method.Type)
+			if err != nil {
+				return "", err
+			}
+			methods = append(methods, method.Name+signature)
+		}
+		return "interface{ " + strings.Join(methods, "; ") + " }", nil
+	}
+	return "", fmt.Errorf("type %v is not supported", typ)
+}
+
+// signature returns parameters and results of the function type
+func (gen *generator) signature(typ reflect.Type) (string, error) {
+	params := make([]string, 0, typ.NumIn())
+	for i := 0; i < typ.NumIn(); i++ {
+		t, err := gen.typeRef(typ.In(i))
+		if err != nil {
+			return "", err
+		}
+		if typ.IsVariadic() && i == typ.NumIn()-1 {
+			t = "..." + strings.TrimPrefix(t, "[]")
+		}
+		params = append(params, t)
+	}
+	results := make([]string, 0, typ.NumOut())
+	for i := 0; i < typ.NumOut(); i++ {
+		t, err := gen.typeRef(typ.Out(i))
+		if err != nil {
+			return "", err
+		}
+		results = append(results, t)
+	}
+	signature := "(" + strings.Join(params, ", ") + ")"
+	switch len(results) {
+	case 0:
+		return signature, nil
+	case 1:
+		return signature + " " + results[0], nil
+	}
+	return signature + " (" + strings.Join(results, ", ") + ")", nil
+}
+
+// joinErrors returns the name of the generated function combining errors of a layer
+func (gen *generator) joinErrors() string {
+	return strings.ToLower(gen.opts.Name[:1]) + gen.opts.Name[1:] + "JoinErrors"
+}
+
+// name chooses names of the parameters and the values passed between nodes
+func (gen *generator) name() {
+	p := gen.p
+	taken := make(map[string]bool)
+	paramName := func(name string) string {
+		if !token.IsIdentifier(name) || token.IsKeyword(name) || gotypes.Universe.Lookup(name) != nil ||
+			gen.aliases[name] || gen.locals[name] || generatedName.MatchString(name) || taken[name] {
+			name = "in" + strconv.Itoa(len(gen.params))
+		}
+		taken[name] = true
+		return name
+	}
+
+	gen.used = make([][]bool, len(p.fns))
+	for i := range p.fns {
+		gen.used[i] = make([]bool, len(p.outTypes[i]))
+	}
+	markUsed := func(pt port) {
+		if pt.index != allValues {
+			gen.used[pt.node][pt.index] = true
+			return
+		}
+		for j := range gen.used[pt.node] {
+			gen.used[pt.node][j] = true
+		}
+	}
+	for i, inputs := range p.inputs {
+		if !p.called(i) {
+			continue
+		}
+		for _, pt := range inputs {
+			markUsed(pt)
+		}
+	}
+	for _, pt := range p.outputPorts() {
+		markUsed(pt)
+	}
+
+	gen.values = make([][]string, len(p.fns))
+	for i := range p.fns {
+		if gen.isArgument[i] {
+			gen.params = append(gen.params, paramName(nodeName(p.g, i)))
+			gen.values[i] = gen.params[len(gen.params)-1:]
+			continue
+		}
+		for j := range p.outTypes[i] {
+			gen.values[i] = append(gen.values[i], fmt.Sprintf("v%d_%d", i, j))
+		}
+	}
+	if p.arguments == nil {
+		// nodes of the first layer take the arguments of the function in order
+		for _, idx := range p.layers[0] {
+			for range p.inTypes[idx] {
+				gen.params = append(gen.params, paramName(""))
+			}
+		}
+	}
+}
+
+func (gen *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&gen.body, format, args...)
+}
+
+// emit writes the body of the generated function
+func (gen *generator) emit() {
+	p := gen.p
+	zeros := make([]string, 0)
+	results := make([]string, 0)
+	for _, pt := range p.outputPorts() {
+		for _, typ := range p.portTypes(pt) {
+			zeros = append(zeros, zeroValue(typ, gen.types[typ]))
+		}
+		results = append(results, gen.portValues(pt)...)
+	}
+	if gen.withError {
+		results = append(results, "nil")
+	}
+	failed := func(err string) string {
+		return "return " + strings.Join(append(append([]string{}, zeros...), err), ", ")
+	}
+
+	// a chain of a single step doesn't check context
+	steps := 2*len(p.layers) - 1
+	if p.outputs != nil {
+		steps++
+	}
+	// consecutive checks are merged as nothing happens between them
+	checked := false
+	checkContext := func() {
+		if gen.withCtx && steps > 1 && !checked {
+			gen.printf("if ctx != nil && ctx.Err() != nil {\n%s\n}\n", failed("ctx.Err()"))
+			checked = true
+		}
+	}
+
+	gen.printf("// %s computes the graph like the function compiled with %T does, without reflection\n", gen.opts.Name, p.ops)
+	gen.printf("%s {\n", gen.header())
+	nextParam := 0
+	for layer, indices := range p.layers {
+		checkContext()
+		errs := make([]string, 0)
+		for _, idx := range indices {
+			if gen.isArgument[idx] {
+				continue
+			}
+			args := make([]string, 0)
+			if gen.withCtx && takesContext(reflect.TypeOf(p.fns[idx])) {
+				args = append(args, "ctx")
+			}
+			if layer == 0 && p.arguments == nil {
+				args = append(args, gen.params[nextParam:nextParam+len(p.inTypes[idx])]...)
+				nextParam += len(p.inTypes[idx])
+			}
+			if !p.called(idx) {
+				continue
+			}
+			for _, pt := range p.inputs[idx] {
+				args = append(args, gen.portValues(pt)...)
+			}
+			lhs := make([]string, 0)
+			for j, name := range gen.values[idx] {
+				if !gen.used[idx][j] {
+					name = "_"
+				}
+				lhs = append(lhs, name)
+			}
+			if gen.withError {
+				lhs = append(lhs, fmt.Sprintf("err%d", idx))
+				errs = append(errs, fmt.Sprintf("err%d", idx))
+			}
+			checked = false
+			call := fmt.Sprintf("%s(%s)", gen.funcs[idx], strings.Join(args, ", "))
+			if strings.Trim(strings.Join(lhs, ""), "_") == "" {
+				gen.printf("%s\n", call)
+			} else {
+				gen.printf("%s := %s\n", strings.Join(lhs, ", "), call)
+			}
+		}
+		switch len(errs) {
+		case 0:
+		case 1:
+			gen.printf("if %s != nil {\n%s\n}\n", errs[0], failed(errs[0]))
+		default:
+			gen.printf(
+				"if err := %s(%s); err != nil {\n%s\n}\n",
+				gen.joinErrors(), strings.Join(errs, ", "), failed("err"),
+			)
+		}
+	}
+	if p.outputs != nil {
+		checkContext()
+	}
+	gen.printf("return %s\n}\n", strings.Join(results, ", "))
+
+	if gen.needJoin {
+		gen.printf(`
+// %[1]s returns the only error as is and combines several ones with errors.Join
+func %[1]s(errs ...error) error {
+	failed := make([]error, 0, len(errs))
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return failed[0]
+	}
+	return %[2]s.Join(failed...)
+}
+`, gen.joinErrors(), gen.imports["errors"])
+	}
+}
+
+// signatureTypes returns types of the parameters and the results of the generated function
+// except for the context and the error
+func (gen *generator) signatureTypes() (params, results []reflect.Type) {
+	p := gen.p
+	params = make([]reflect.Type, 0)
+	if p.arguments != nil {
+		for _, idx := range p.arguments {
+			params = append(params, p.inTypes[idx]...)
+		}
+	} else {
+		for _, idx := range p.layers[0] {
+			params = append(params, p.inTypes[idx]...)
+		}
+	}
+	results = make([]reflect.Type, 0)
+	for _, pt := range p.outputPorts() {
+		results = append(results, p.portTypes(pt)...)
+	}
+	return params, results
+}
+
+// header returns the signature of the generated function
+func (gen *generator) header() string {
+	paramTypes, resultTypes := gen.signatureTypes()
+	params := make([]string, 0, len(paramTypes)+1)
+	if gen.withCtx {
+		params = append(params, "ctx "+gen.imports["context"]+".Context")
+	}
+	for i, typ := range paramTypes {
+		params = append(params, gen.params[i]+" "+gen.types[typ])
+	}
+	results := make([]string, 0, len(resultTypes)+1)
+	for _, typ := range resultTypes {
+		results = append(results, gen.types[typ])
+	}
+	if gen.withError {
+		results = append(results, "error")
+	}
+	return fmt.Sprintf("func %s(%s) (%s)", gen.opts.Name, strings.Join(params, ", "), strings.Join(results, ", "))
+}
+
+// portValues returns the expressions of the values the port refers to
+func (gen *generator) portValues(pt port) []string {
+	if pt.index == allValues {
+		return gen.values[pt.node]
+	}
+	return gen.values[pt.node][pt.index : pt.index+1]
+}
+
+// zeroValue returns the expression of the zero value of the type rendered as ref
+func zeroValue(typ reflect.Type, ref string) string {
+	switch typ.Kind() {
+	case reflect.Bool:
+		return "false"
+	case reflect.String:
+		return `""`
+	case reflect.Struct, reflect.Array:
+		return ref + "{}"
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Chan, reflect.Func, reflect.Interface, reflect.UnsafePointer:
+		return "nil"
+	}
+	return "0"
+}
+
+// source returns the whole generated file
+func (gen *generator) source() []byte {
+	var buf bytes.Buffer
+	switch {
+	case gen.stub:
+		fmt.Fprintf(&buf, "//go:build %s\n\n", gen.opts.BuildTag)
+	case gen.opts.BuildTag != "":
+		fmt.Fprintf(&buf, "//go:build !%s\n\n", gen.opts.BuildTag)
+	}
+	fmt.Fprintf(&buf, "// Code generated by gush. DO NOT EDIT.\n\npackage %s\n\n", gen.opts.Package)
+	paths := make([]string, 0, len(gen.imports))
+	for path := range gen.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	specs := make([]string, 0, len(paths))
+	for _, path := range paths {
+		alias := gen.imports[path]
+		// package names of the standard library match their paths
+		if strings.Contains(strings.SplitN(path, "/", 2)[0], ".") || path[strings.LastIndex(path, "/")+1:] != alias {
+			specs = append(specs, fmt.Sprintf("%s %q", alias, path))
+		} else {
+			specs = append(specs, strconv.Quote(path))
+		}
+	}
+	switch len(specs) {
+	case 0:
+	case 1:
+		fmt.Fprintf(&buf, "import %s\n\n", specs[0])
+	default:
+		fmt.Fprintf(&buf, "import (\n%s\n)\n\n", strings.Join(specs, "\n"))
+	}
+	buf.Write(gen.body.Bytes())
+	return buf.Bytes()
+}
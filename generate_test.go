@@ -0,0 +1,233 @@
+package compose
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grihabor/gush/builder"
+)
+
+func positive(a int) (int, error) {
+	if a <= 0 {
+		return 0, errors.New("not positive")
+	}
+	return a, nil
+}
+
+func TestGenerate(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	gb.Named("double", double).Inputs("a")
+	gb.Named("halve", halve).Inputs("double")
+	gb.Node(halve).Inputs("a")
+	gb.Outputs("halve", builder.Out(halve, 0), "double")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	source, err := Generate(g, AllArgs{}, GenerateOptions{
+		Package:     "compose",
+		PackagePath: "github.com/grihabor/gush",
+		Name:        "Compute",
+		BuildTag:    "gush",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `//go:build !gush
+
+// Code generated by gush. DO NOT EDIT.
+
+package compose
+
+// Compute computes the graph like the function compiled with compose.AllArgs does, without reflection
+func Compute(a int) (int, int, int) {
+	v1_0 := double(a)
+	v3_0 := halve(a)
+	v2_0 := halve(v1_0)
+	return v2_0, v3_0, v1_0
+}
+`, string(source))
+}
+
+func TestGenerate_LastArgError(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Input("in", reflect.TypeOf(""))
+	gb.Node(strconv.Atoi).Inputs("in")
+	gb.Named("a", positive).Inputs(strconv.Atoi)
+	gb.Named("b", positive).Inputs(strconv.Atoi)
+	gb.Outputs("a", "b")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	source, err := Generate(g, LastArgError{}, GenerateOptions{
+		Package:     "compose",
+		PackagePath: "github.com/grihabor/gush",
+		Name:        "Parse",
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, string(source), `import (
+	"errors"
+	"strconv"
+)`)
+	assert.Contains(t, string(source), `func Parse(in string) (int, int, error) {
+	v1_0, err1 := strconv.Atoi(in)
+	if err1 != nil {
+		return 0, 0, err1
+	}
+	v2_0, err2 := positive(v1_0)
+	v3_0, err3 := positive(v1_0)
+	if err := parseJoinErrors(err2, err3); err != nil {
+		return 0, 0, err
+	}
+	return v2_0, v3_0, nil
+}`)
+}
+
+func TestGenerate_Pruned(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Node(double)
+	gb.Node(positive)
+	gb.Node(halve).Inputs(double)
+	gb.Outputs(double)
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	source, err := Generate(g, AllArgs{}, GenerateOptions{
+		Package:     "compose",
+		PackagePath: "github.com/grihabor/gush",
+		Name:        "Compute",
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, string(source), `func Compute(in0 int, in1 int) int {
+	v0_0 := double(in0)
+	return v0_0
+}`)
+}
+
+func TestGenerateStub(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Input("in", reflect.TypeOf(""))
+	gb.Node(strconv.Atoi).Inputs("in")
+	gb.Named("a", positive).Inputs(strconv.Atoi)
+	gb.Outputs("a")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	opts := GenerateOptions{Package: "compose", PackagePath: "github.com/grihabor/gush", Name: "Parse"}
+	_, err = GenerateStub(g, ContextArgs{}, opts)
+	assert.EqualError(t, err, "stub needs a build tag to replace the generated function")
+
+	opts.BuildTag = "gush"
+	source, err := GenerateStub(g, ContextArgs{}, opts)
+	assert.NoError(t, err)
+	// functions of the nodes are not imported
+	assert.Equal(t, `//go:build gush
+
+// Code generated by gush. DO NOT EDIT.
+
+package compose
+
+import "context"
+
+// Parse is replaced by the generated function in builds without the gush tag
+func Parse(ctx context.Context, in string) (int, error) {
+	panic("Parse is generated by gush, build without the gush tag")
+}
+`, string(source))
+
+	generated, err := Generate(g, ContextArgs{}, opts)
+	assert.NoError(t, err)
+	assert.Contains(t, string(generated), "func Parse(ctx context.Context, in string) (int, error) {\n")
+}
+
+func TestGenerate_Unsupported(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Node(func() int { return 1 })
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	opts := GenerateOptions{Package: "other", PackagePath: "example.com/other", Name: "F"}
+	_, err = Generate(g, AllArgs{}, opts)
+	assert.Regexp(t, `^can't generate node .*: github\.com/grihabor/gush\.TestGenerate_Unsupported\.func1 is not a top-level function$`, err.Error())
+
+	_, err = Generate(g, ParallelArgs{}, opts)
+	assert.EqualError(t, err, "can't generate graph compiled with compose.ParallelArgs")
+}
+
+// TestGenerate_Build runs the generated functions and compares their results
+// with the results of the compiled ones
+func TestGenerate_Build(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil || testing.Short() {
+		t.Skip("go tool is needed to build the generated source")
+	}
+	opts := GenerateOptions{Package: "main", PackagePath: "example.com/generated"}
+
+	gb := NewGraphBuilder()
+	gb.Input("s", reflect.TypeOf(""))
+	gb.Input("n", reflect.TypeOf(0))
+	gb.Node(strings.ToUpper).Inputs("s")
+	gb.Node(strings.Repeat).Inputs(strings.ToUpper, "n")
+	gb.Node(utf8.RuneCountInString).Inputs(strings.Repeat)
+	gb.Node(strings.TrimSpace).Inputs("s")
+	gb.Outputs(strings.Repeat, utf8.RuneCountInString)
+	compute, err := gb.SafeBuild()
+	assert.NoError(t, err)
+	opts.Name = "Compute"
+	computeSource, err := Generate(compute, AllArgs{}, opts)
+	assert.NoError(t, err)
+
+	gb = NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(""))
+	gb.Input("b", reflect.TypeOf(""))
+	gb.Named("parseA", strconv.Atoi).Inputs("a")
+	gb.Named("parseB", strconv.Atoi).Inputs("b")
+	gb.Outputs("parseB", "parseA")
+	parse, err := gb.SafeBuild()
+	assert.NoError(t, err)
+	opts.Name = "Parse"
+	parseSource, err := Generate(parse, LastArgError{}, opts)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":     "module example.com/generated\n\ngo 1.21\n",
+		"compute.go": string(computeSource),
+		"parse.go":   string(parseSource),
+		"main.go": `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println(Compute("ab ", 2))
+	fmt.Println(Parse("1", "2"))
+	fmt.Println(Parse("1", "x"))
+}
+`,
+	}
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	cmd := exec.Command(goTool, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=")
+	output, err := cmd.CombinedOutput()
+	if !assert.NoError(t, err, "%s", output) {
+		return
+	}
+
+	computeCompiled := Compile(compute, AllArgs{}).(func(string, int) (string, int))
+	parseCompiled := Compile(parse, LastArgError{}).(func(string, string) (int, int, error))
+	expected := fmt.Sprintln(computeCompiled("ab ", 2)) +
+		fmt.Sprintln(parseCompiled("1", "2")) +
+		fmt.Sprintln(parseCompiled("1", "x"))
+	assert.Equal(t, expected, string(output))
+}
@@ -1,42 +1,18 @@
 package compose
 
 import (
-	// "fmt"
+	"context"
 	"fmt"
 	"reflect"
-)
-
-// readyToBeCalculated returns all the nodes which already has all inputs calculated
-func readyToBeCalculated(g G, calculated []int) []int {
-
-	isCalculated := func(i int) bool {
-		for _, calculatedIndex := range calculated {
-			if i == calculatedIndex {
-				return true
-			}
-		}
-		return false
-	}
 
-	allInputsCalculated := func(inputs []int) bool {
-		for _, input := range inputs {
-			if !isCalculated(input) {
-				return false
-			}
-		}
-		return true
-	}
+	"github.com/grihabor/gush/internal/dag"
+	"github.com/grihabor/gush/internal/errs"
+	"github.com/grihabor/gush/internal/funcinfo"
+)
 
-	result := make([]int, 0)
-	g.ForEachNode(func(i int, inputs []int) {
-		if isCalculated(i) {
-			return
-		}
-		if allInputsCalculated(inputs) {
-			result = append(result, i)
-		}
-	})
-	return result
+// layers groups nodes so that every node depends only on the nodes from the previous groups
+func layers(g G) ([][]int, error) {
+	return dag.Layers(g.NodeCount(), g.Inputs)
 }
 
 type G interface {
@@ -46,79 +22,282 @@ type G interface {
 	ForEachNode(func(int, []int))
 }
 
-func glue(g G, donorLayerIndices []int, recipientLayerIndices []int) (interface{}, error) {
-	donorLayer, recipientLayer := g.Nodes(donorLayerIndices), g.Nodes(recipientLayerIndices)
-	donorLayerTypes, recipientLayerTypes := types(donorLayer), types(recipientLayer)
-	donorLayerOutputTypes, err := mapEach(out, donorLayerTypes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve donor layer %v output types: %w", donorLayerTypes, err)
+// glue takes outputs of the donors and returns the values referred to by the wanted ports
+func glue(p *prepared, donorIndices []int, wanted []port) (interface{}, error) {
+	offsets := make(map[int]int)
+	donorOutputTypes := make([]reflect.Type, 0)
+	for _, idx := range donorIndices {
+		offsets[idx] = len(donorOutputTypes)
+		donorOutputTypes = append(donorOutputTypes, p.outTypes[idx]...)
 	}
-	recipientLayerInputTypes, err := mapEach(in, recipientLayerTypes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve recipient layer %v input types: %w", donorLayerTypes, err)
+
+	// positions are indices of the wanted values among the donor outputs
+	positions := make([]int, 0)
+	resultTypes := make([]reflect.Type, 0)
+	for _, pt := range wanted {
+		offset, ok := offsets[pt.node]
+		if !ok {
+			return nil, fmt.Errorf("node %s is not calculated yet", describeNode(p.g, pt.node))
+		}
+		if pt.index < 0 {
+			for i := range p.outTypes[pt.node] {
+				positions = append(positions, offset+i)
+			}
+		} else {
+			positions = append(positions, offset+pt.index)
+		}
+		resultTypes = append(resultTypes, p.portTypes(pt)...)
 	}
-	layer1Flatten := flatten(donorLayerOutputTypes)
-	layer2Flatten := flatten(recipientLayerInputTypes)
 
-	offsets := make([]int, 1)
-	indicesMapping := make(map[int]int)
-	for offsetIndex, types := range donorLayerOutputTypes {
-		next := offsets[len(offsets)-1] + len(types)
-		offsets = append(offsets, next)
-		indicesMapping[donorLayerIndices[offsetIndex]] = offsetIndex
+	// the values are moved within the arguments if none of them is overwritten before it is moved
+	overwrite := true
+	for i, position := range positions {
+		overwrite = overwrite && position >= i
 	}
 
-	resultFuncType := reflect.FuncOf(layer1Flatten, layer2Flatten, false)
+	resultFuncType := reflect.FuncOf(donorOutputTypes, resultTypes, false)
 	return reflect.MakeFunc(resultFuncType, func(args []reflect.Value) []reflect.Value {
-		result := make([]reflect.Value, 0, len(recipientLayerInputTypes))
-		for _, idx := range recipientLayerIndices {
-			inputIndices := g.Inputs(idx)
-			for _, inputIndex := range inputIndices {
-				offsetIndex := indicesMapping[inputIndex]
-				result = append(result, args[offsets[offsetIndex]:offsets[offsetIndex+1]]...)
-			}
+		result := args[:0]
+		if !overwrite {
+			result = make([]reflect.Value, 0, len(positions))
+		}
+		for _, position := range positions {
+			result = append(result, args[position])
 		}
 		return result
 	}).Interface(), nil
 }
 
+// passThrough returns a function which returns its arguments as is
+func passThrough(types []reflect.Type) interface{} {
+	resultFuncType := reflect.FuncOf(types, types, false)
+	return reflect.MakeFunc(resultFuncType, func(args []reflect.Value) []reflect.Value {
+		return args
+	}).Interface()
+}
+
+// discard returns a function taking values of the types and returning nothing
+func discard(types []reflect.Type) interface{} {
+	resultFuncType := reflect.FuncOf(types, nil, false)
+	return reflect.MakeFunc(resultFuncType, func([]reflect.Value) []reflect.Value {
+		return nil
+	}).Interface()
+}
+
 func node(g G, idx int) interface{} {
 	return g.Nodes([]int{idx})[0]
 }
 
+// named is implemented by graphs with named nodes, like builder.Graph
+type named interface {
+	Name(int) string
+}
+
+// nodeName returns the name of the node if it has one
+func nodeName(g G, idx int) string {
+	if n, ok := g.(named); ok {
+		return n.Name(idx)
+	}
+	return ""
+}
+
+// describeNode returns the node name if any, function name and location
+func describeNode(g G, idx int) string {
+	if name := nodeName(g, idx); name != "" {
+		return fmt.Sprintf("%q %s", name, funcinfo.Describe(node(g, idx)))
+	}
+	return funcinfo.Describe(node(g, idx))
+}
+
+// nodeStep identifies the node in errors
+func nodeStep(g G, idx int) Step {
+	return Step{Index: idx, Node: describeNode(g, idx)}
+}
+
+// validate makes sure all the inputs refer to existing nodes and there are no cycles
+func validate(g G) error {
+	if g.NodeCount() == 0 {
+		return fmt.Errorf("graph has no nodes")
+	}
+	var err error
+	g.ForEachNode(func(i int, inputs []int) {
+		for _, input := range inputs {
+			if err == nil && (input < 0 || input >= g.NodeCount()) {
+				err = fmt.Errorf(
+					"node %s at #%d takes input from unknown node #%d",
+					describeNode(g, i), i, input,
+				)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if cycle := dag.FindCycle(g.NodeCount(), g.Inputs); cycle != nil {
+		return errs.NewCycleError(cycle, func(idx int) string { return describeNode(g, idx) })
+	}
+	return nil
+}
+
+// Ops defines how functions of the graph are combined.
+// Ops changing the types the functions take or pass on implement In, Out and Lift as well,
+// see inputOps and outputOps.
 type Ops interface {
 	Stack(...interface{}) (interface{}, error)
 	Chain(...interface{}) (interface{}, error)
 }
 
-// build resulting function
-func SafeCompile(g G, ops Ops) (interface{}, error) {
-	calculated := make([]int, 0)
-	indicesToBeChained := make([][]int, 0)
-	for len(calculated) < g.NodeCount() {
-		readyIndices := readyToBeCalculated(g, calculated)
-		indicesToBeChained = append(indicesToBeChained, readyIndices)
-		calculated = append(calculated, readyIndices...)
+// inputOps is implemented by ops passing the functions something apart from their inputs,
+// like ContextArgs
+type inputOps interface {
+	// In returns types which a function takes from the functions it depends on
+	In(reflect.Type) ([]reflect.Type, error)
+}
+
+// opsIn returns types which the function takes from its inputs according to ops,
+// all its arguments by default
+func opsIn(ops Ops, fn reflect.Type) ([]reflect.Type, error) {
+	if o, ok := ops.(inputOps); ok {
+		return o.In(fn)
+	}
+	return in(fn)
+}
+
+// outputOps is implemented by ops passing on something else than all the values
+// returned by the functions, like LastArgError
+type outputOps interface {
+	// Out returns types which a function passes to the functions depending on it
+	Out(reflect.Type) ([]reflect.Type, error)
+	// Lift turns a plain function into a step which can be chained with the stacked ones
+	Lift(interface{}) (interface{}, error)
+}
+
+// opsOut returns types which the function passes on according to ops, all its outputs by default
+func opsOut(ops Ops, fn reflect.Type) ([]reflect.Type, error) {
+	if o, ok := ops.(outputOps); ok {
+		return o.Out(fn)
+	}
+	return out(fn)
+}
+
+// opsLift adapts a plain function to ops, it is kept as is by default
+func opsLift(ops Ops, fn interface{}) (interface{}, error) {
+	if o, ok := ops.(outputOps); ok {
+		return o.Lift(fn)
+	}
+	return fn, nil
+}
+
+// SafeCompile builds the resulting function, options are applied to every node,
+// see Wrap. If the graph declares its outputs, the nodes feeding none of them are not called.
+func SafeCompile(g G, ops Ops, opts ...Option) (interface{}, error) {
+	o := newOptions(opts)
+	if o.tracer != nil && !passesContext(ops) {
+		return nil, fmt.Errorf("tracing needs ops passing context to the nodes, like ContextArgs")
+	}
+	p, err := prepare(g, ops, o)
+	if err != nil {
+		return nil, err
+	}
+	var compiled interface{}
+	if pl, fnType, ok := lower(p, o.lazy); ok {
+		compiled = reflect.MakeFunc(fnType, pl.run).Interface()
+	} else if o.lazy {
+		return nil, fmt.Errorf("lazy evaluation needs the ops defined in this package, got %T", ops)
+	} else {
+		compiled, err = compile(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if o.tracer != nil {
+		return traceGraph(o.tracer, compiled)
 	}
+	return compiled, nil
+}
+
+// passesContext reports whether ops passes context to the functions apart from their inputs
+func passesContext(ops Ops) bool {
+	inTypes, err := opsIn(ops, reflect.TypeOf(func(context.Context) {}))
+	return err == nil && len(inTypes) == 0
+}
+
+// compile chains the layers of the prepared graph, it is used for the ops
+// which can't be lowered to a plan
+func compile(p *prepared) (interface{}, error) {
+	ops := p.ops
+	carriedIndices := p.carried()
 	toBeChained := make([]interface{}, 0)
-	for i, indices := range indicesToBeChained {
-		if i > 0 {
-			prevIndices := indicesToBeChained[i-1]
-			glued, err := glue(g, prevIndices, indices)
+	// donors are the nodes which outputs are returned by the last stacked layer
+	donors := make([]int, 0)
+	addGlue := func(wanted []port) error {
+		glued, err := glue(p, donors, wanted)
+		if err != nil {
+			return err
+		}
+		lifted, err := opsLift(ops, glued)
+		if err != nil {
+			return fmt.Errorf("failed to lift glue function %v: %w", reflect.TypeOf(glued), err)
+		}
+		toBeChained = append(toBeChained, lifted)
+		return nil
+	}
+	for i, indices := range p.layers {
+		ready := make([]interface{}, 0, len(indices))
+		called := make([]int, 0, len(indices))
+		for _, idx := range indices {
+			if p.called(idx) {
+				ready = append(ready, p.fns[idx])
+				called = append(called, idx)
+				continue
+			}
+			// the node is not called, but the stack still takes its arguments
+			skip, err := opsLift(ops, discard(p.inTypes[idx]))
 			if err != nil {
+				return nil, fmt.Errorf("failed to lift discard function: %w", err)
+			}
+			ready = append(ready, skip)
+		}
+		if i > 0 {
+			wanted := make([]port, 0)
+			for _, idx := range indices {
+				wanted = append(wanted, p.inputs[idx]...)
+			}
+			for _, idx := range carriedIndices[i] {
+				wanted = append(wanted, port{node: idx, index: allValues})
+			}
+			if err := addGlue(wanted); err != nil {
 				return nil, fmt.Errorf(
-					"failed to glue function %v at %d and function %v at %d",
-					node(g, i-1), i-1, node(g, i), i,
+					"failed to glue layer #%d %v and layer #%d %v: %w",
+					i-1, types(p.nodes(donors)), i, types(ready), err,
 				)
 			}
-			toBeChained = append(toBeChained, glued)
 		}
-		ready := g.Nodes(indices)
+		if len(carriedIndices[i]) > 0 {
+			carriedOutputTypes := make([][]reflect.Type, 0)
+			for _, idx := range carriedIndices[i] {
+				carriedOutputTypes = append(carriedOutputTypes, p.outTypes[idx])
+			}
+			carry, err := opsLift(ops, passThrough(flatten(carriedOutputTypes)))
+			if err != nil {
+				return nil, fmt.Errorf("failed to lift pass through function: %w", err)
+			}
+			ready = append(ready, carry)
+		}
 		stacked, err := ops.Stack(ready...)
 		if err != nil {
 			return nil, fmt.Errorf("failed to stack functions %v: %w", types(ready), err)
 		}
 		toBeChained = append(toBeChained, stacked)
+		donors = append(called, carriedIndices[i]...)
+	}
+	if p.outputs != nil {
+		if err := addGlue(p.outputs); err != nil {
+			return nil, fmt.Errorf("failed to glue outputs: %w", err)
+		}
+	}
+	if len(toBeChained) == 1 {
+		return toBeChained[0], nil
 	}
 	chained, err := ops.Chain(toBeChained...)
 	if err != nil {
@@ -127,8 +306,8 @@ func SafeCompile(g G, ops Ops) (interface{}, error) {
 	return chained, nil
 }
 
-func Compile(g G, ops Ops) interface{} {
-	result, err := SafeCompile(g, ops)
+func Compile(g G, ops Ops, opts ...Option) interface{} {
+	result, err := SafeCompile(g, ops, opts...)
 	if err != nil {
 		panic(fmt.Sprintf("%v", err))
 	}
@@ -1,6 +1,10 @@
 package compose
 
 import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -86,3 +90,518 @@ func TestNewGraph_MultipleOutputArgs(t *testing.T) {
 	assert.Equal(t, float64(26), x)
 	assert.Equal(t, float64(26), y)
 }
+
+func TestNewGraph_TypeMismatch(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	src := func() []string { return nil }
+	gb.Node(func([]int) int { return 0 }).Inputs(src)
+
+	_, err := gb.Build()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "arg #0: []string is not assignable to []int")
+
+	g := testGraph{
+		node: []interface{}{func([]int) int { return 0 }, src},
+		edge: [][]int{{1}, {}},
+	}
+	_, err = SafeCompile(g, AllArgs{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "[]string is not assignable to []int")
+}
+
+func TestNewGraph_LastArgError(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	src := func(s string) (int, error) { return strconv.Atoi(s) }
+	gb.Node(func(a int) (int, error) { return a / 2, nil }).Inputs(src)
+	gb.Node(func(a int) (int, error) { return a / 3, nil }).Inputs(src)
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	fn, err := SafeCompile(g, LastArgError{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	fun := fn.(func(string) (int, int, error))
+	a, b, err := fun("42")
+	assert.NoError(t, err)
+	assert.Equal(t, 21, a)
+	assert.Equal(t, 14, b)
+
+	_, _, err = fun("forty two")
+	assert.Error(t, err)
+}
+
+func TestNewGraph_SkipLayers(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	// diamond with a shortcut edge from src to sum
+	src := func(a int) int { return a }
+	double := func(a int) int { return a * 2 }
+	triple := func(a int) int { return a * 3 }
+	incr := func(a int) int { return a + 1 }
+	sum := func(a, b, c int) int { return a*100 + b*10 + c }
+	gb.Node(double).Inputs(src)
+	gb.Node(triple).Inputs(src)
+	gb.Node(incr).Inputs(double)
+	gb.Node(sum).Inputs(incr, triple, src)
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	fn, err := SafeCompile(g, AllArgs{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 3*100+3*10+1, fn.(func(int) int)(1))
+}
+
+func TestNewGraph_SkipLayersWithError(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	src := func(s string) (int, error) { return strconv.Atoi(s) }
+	incr := func(a int) (int, error) { return a + 1, nil }
+	sum := func(a, b int) (int, error) { return a + b, nil }
+	gb.Node(incr).Inputs(src)
+	gb.Node(sum).Inputs(incr, src)
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	fn, err := SafeCompile(g, LastArgError{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	result, err := fn.(func(string) (int, error))("20")
+	assert.NoError(t, err)
+	assert.Equal(t, 41, result)
+}
+
+func double(a int) int { return a * 2 }
+
+func halve(a int) int { return a / 2 }
+
+func TestNewGraph_Cycle(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+	gb.Node(double).Inputs(halve)
+	gb.Node(halve).Inputs(double)
+
+	_, err := gb.Build()
+	assert.Error(t, err)
+	assert.Regexp(t, `graph has a cycle: .*\.double \(graph_test\.go:\d+\) -> .*\.halve \(graph_test\.go:\d+\) -> .*\.double`, err.Error())
+}
+
+func TestNewGraph_ArityMismatch(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+	gb.Node(func(a, b int) int { return a + b }).Inputs(double)
+
+	_, err := gb.Build()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "takes 2 arguments, got 1 values")
+}
+
+// testGraph is a minimal G implementation
+type testGraph struct {
+	node []interface{}
+	edge [][]int
+}
+
+func (g testGraph) NodeCount() int { return len(g.node) }
+
+func (g testGraph) Inputs(idx int) []int { return g.edge[idx] }
+
+func (g testGraph) ForEachNode(callback func(int, []int)) {
+	for i, inputs := range g.edge {
+		callback(i, inputs)
+	}
+}
+
+func (g testGraph) Nodes(indices []int) []interface{} {
+	nodes := make([]interface{}, 0, len(indices))
+	for _, idx := range indices {
+		nodes = append(nodes, g.node[idx])
+	}
+	return nodes
+}
+
+func TestSafeCompile_Cycle(t *testing.T) {
+	g := testGraph{
+		node: []interface{}{double, halve, double},
+		edge: [][]int{{}, {2}, {1}},
+	}
+	_, err := SafeCompile(g, AllArgs{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "graph has a cycle")
+}
+
+func TestSafeCompile_UnknownInput(t *testing.T) {
+	g := testGraph{
+		node: []interface{}{double},
+		edge: [][]int{{3}},
+	}
+	_, err := SafeCompile(g, AllArgs{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "takes input from unknown node #3")
+}
+
+func TestNewGraph_Named(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	gb.Named("sum", func(a, b int) int { return a + b }).Inputs("double", "twice")
+	// the same function under different names makes different nodes
+	gb.Named("double", double).Inputs("src")
+	gb.Named("twice", double).Inputs("src")
+	gb.Named("src", func(a int) int { return a })
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 4, g.NodeCount())
+	fn, err := SafeCompile(g, AllArgs{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 20, fn.(func(int) int)(5))
+}
+
+func TestNewGraph_NamedLoop(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	names := make([]interface{}, 0)
+	for i := 0; i < 3; i++ {
+		i := i
+		name := fmt.Sprintf("add%d", i)
+		gb.Named(name, func(a int) int { return a + i }).Inputs("src")
+		names = append(names, name)
+	}
+	gb.Named("src", func(a int) int { return a })
+	gb.Named("sum", func(a, b, c int) int { return a + b + c }).Inputs(names...)
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	fn, err := SafeCompile(g, AllArgs{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 3+0+1+2, fn.(func(int) int)(1))
+}
+
+func TestNewGraph_UnknownName(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+	gb.Named("double", double).Inputs("src")
+
+	_, err := gb.Build()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown node "src"`)
+}
+
+func TestNewGraph_DuplicateName(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+	gb.Named("double", double)
+	gb.Named("double", halve)
+
+	_, err := gb.Build()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `duplicate node name "double"`)
+}
+
+func TestNewGraph_UnknownOutput(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+	gb.Node(double).Inputs(halve)
+	for _, output := range []interface{}{strconv.Itoa, builder.Out(strconv.Itoa, 0)} {
+		gb.Outputs(double, output)
+
+		_, err := gb.Build()
+		assert.ErrorContains(t, err, "unknown node strconv.Itoa")
+	}
+}
+
+func TestSafeCompile_EmptyGraph(t *testing.T) {
+	g, err := NewGraphBuilder().SafeBuild()
+	assert.NoError(t, err)
+	_, err = SafeCompile(g, AllArgs{})
+	assert.EqualError(t, err, "invalid graph: graph has no nodes")
+	_, err = SafeCompileDataflow(g, 0)
+	assert.EqualError(t, err, "invalid graph: graph has no nodes")
+	_, err = SafeNewSession(g, AllArgs{}, nil)
+	assert.EqualError(t, err, "invalid graph: graph has no nodes")
+}
+
+func TestNewGraph_EmptyName(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+	gb.Named("", double)
+
+	_, err := gb.Build()
+	assert.ErrorContains(t, err, "has an empty name")
+}
+
+func TestNewGraph_NamedFunctionInput(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+	// the function refers to the named node added later instead of a new unnamed one
+	gb.Named("halve", halve).Inputs(double)
+	gb.Named("double", double).Inputs("src")
+	gb.Named("src", func(a int) int { return a })
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 3, g.NodeCount())
+	assert.Equal(t, 5, Compile(g, AllArgs{}).(func(int) int)(5))
+}
+
+func TestNewGraph_AmbiguousFunctionInput(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+	gb.Named("halve", halve).Inputs(double)
+	gb.Named("double", double)
+	gb.Named("twice", double)
+
+	_, err := gb.Build()
+	assert.ErrorContains(t, err, `is added as nodes "double", "twice", refer to one of them by name`)
+}
+
+func TestNewGraph_Outputs(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	ab := func(a int) (int, string) { return a, strconv.Itoa(a) }
+	gb.Named("double", double).Inputs("src")
+	gb.Named("src", func(a int) int { return a })
+	gb.Named("sum", func(a, b int) int { return a + b }).Inputs("double", "src")
+	gb.Named("ab", ab).Inputs("src")
+	gb.Outputs(builder.Out("ab", 1), "double", "sum")
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, ops := range []Ops{AllArgs{}, ParallelArgs{}} {
+		fn, err := SafeCompile(g, ops)
+		if !assert.NoError(t, err) {
+			return
+		}
+		s, d, sum := fn.(func(int) (string, int, int))(4)
+		assert.Equal(t, "4", s)
+		assert.Equal(t, 8, d)
+		assert.Equal(t, 12, sum)
+	}
+
+	fn, err := SafeCompileDataflow(g, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	s, d, sum := fn.(func(int) (string, int, int))(4)
+	assert.Equal(t, []interface{}{"4", 8, 12}, []interface{}{s, d, sum})
+}
+
+func TestNewGraph_OutputsWithError(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	gb.Named("parse", strconv.Atoi)
+	gb.Named("incr", func(a int) (int, error) { return a + 1, nil }).Inputs("parse")
+	gb.Outputs("parse", "incr", "parse")
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	fn, err := SafeCompile(g, LastArgError{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	a, b, c, err := fn.(func(string) (int, int, int, error))("4")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 5, 4}, []int{a, b, c})
+}
+
+func TestNewGraph_OutputIndexOutOfRange(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+	gb.Named("double", double)
+	gb.Outputs(builder.Out("double", 1))
+
+	_, err := gb.Build()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "returns 1 values, no value at index 1")
+}
+
+func TestNewGraph_Inputs(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	gb.Named("repeat", strings.Repeat).Inputs("name", "count")
+	gb.Named("greet", func(name string) string { return "hello " + name }).Inputs("name")
+	gb.Named("one", func() int { return 1 })
+	gb.Named("sum", func(a, b int) int { return a + b }).Inputs("count", "one")
+	// inputs are the compiled function arguments in the declared order
+	gb.Input("name", reflect.TypeOf(""))
+	gb.Input("count", reflect.TypeOf(0))
+	gb.Outputs("repeat", "greet", "sum")
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, ops := range []Ops{AllArgs{}, ParallelArgs{}} {
+		fn, err := SafeCompile(g, ops)
+		if !assert.NoError(t, err) {
+			return
+		}
+		values := reflect.ValueOf(fn).Call([]reflect.Value{reflect.ValueOf("gush"), reflect.ValueOf(2)})
+		assert.Equal(t, "gushgush", values[0].Interface())
+		assert.Equal(t, "hello gush", values[1].Interface())
+		assert.Equal(t, 3, values[2].Interface())
+	}
+
+	fn, err := SafeCompileDataflow(g, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	repeated, greeting, sum := fn.(func(string, int) (string, string, int))("gush", 2)
+	assert.Equal(t, []interface{}{"gushgush", "hello gush", 3}, []interface{}{repeated, greeting, sum})
+}
+
+func TestNewGraph_InputsWithError(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	gb.Named("parse", strconv.Atoi).Inputs("number")
+	gb.Input("number", reflect.TypeOf(""))
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	fn, err := SafeCompile(g, LastArgError{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	result, err := fn.(func(string) (int, error))("42")
+	assert.NoError(t, err)
+	assert.Equal(t, 42, result)
+}
+
+func TestNewGraph_UndeclaredInput(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	gb.Named("greet", func(greeting, name string) string { return greeting + name }).Inputs("hello", "name")
+	gb.Named("hello", func(s string) string { return s })
+	gb.Input("name", reflect.TypeOf(""))
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = SafeCompile(g, AllArgs{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not declared as graph inputs")
+}
+
+func TestNewGraph_Ports(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	ab := func(a int) (int, string) { return a, strconv.Itoa(a) }
+	c := func(a int) (float64, bool) { return float64(a) / 2, a > 0 }
+	gb.Named("ab", ab).Inputs("src")
+	gb.Named("c", c).Inputs("src")
+	gb.Named("src", func(a int) int { return a })
+	gb.Named("format", func(s string, f float64) string {
+		return fmt.Sprintf("%s/%.1f", s, f)
+	}).Inputs(builder.Out("ab", 1), builder.Out("c", 0))
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	fn, err := SafeCompile(g, AllArgs{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "5/2.5", fn.(func(int) string)(5))
+
+	fn, err = SafeCompileDataflow(g, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "5/2.5", fn.(func(int) string)(5))
+}
+
+func TestNewGraph_PortsWithError(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	split := func(s string) (string, string, error) {
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("no colon in %q", s)
+		}
+		return parts[0], parts[1], nil
+	}
+	gb.Named("split", split)
+	gb.Named("port", strconv.Atoi).Inputs(builder.Out("split", 1))
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	fn, err := SafeCompile(g, LastArgError{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	port, err := fn.(func(string) (int, error))("localhost:8080")
+	assert.NoError(t, err)
+	assert.Equal(t, 8080, port)
+}
+
+func TestNewGraph_PortTypeMismatch(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	ab := func() (int, string) { return 0, "" }
+	gb.Node(func(a, b int) int { return a + b }).Inputs(builder.Out(ab, 0), builder.Out(ab, 1))
+
+	_, err := gb.Build()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "arg #1: string is not assignable to int")
+}
+
+func TestNewGraph_PortCountMismatch(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	ab := func() (int, int) { return 0, 0 }
+	gb.Node(func(a int) int { return a }).Inputs(builder.Out(ab, 0), builder.Out(ab, 1))
+
+	_, err := gb.Build()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "takes 1 arguments, got 2 values")
+}
+
+// plainOps implements only the required methods of Ops
+type plainOps struct{}
+
+func (plainOps) Stack(functions ...interface{}) (interface{}, error) {
+	return SafeStack(functions...)
+}
+
+func (plainOps) Chain(functions ...interface{}) (interface{}, error) {
+	return SafeChain(functions...)
+}
+
+func TestSafeCompile_PlainOps(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	gb.Named("double", double).Inputs("a")
+	gb.Named("sum", func(a, b int) int { return a + b }).Inputs("double", "a")
+	gb.Outputs("sum", "double")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	fn, err := SafeCompile(g, plainOps{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	sum, doubled := fn.(func(int) (int, int))(3)
+	assert.Equal(t, []int{9, 6}, []int{sum, doubled})
+}
@@ -0,0 +1,91 @@
+// Package dag contains algorithms shared by the graph builder and the compiler
+package dag
+
+import "fmt"
+
+// FindCycle returns indices of the nodes forming a cycle in the data flow order,
+// the first node is repeated at the end. It returns nil if there is no cycle.
+func FindCycle(count int, inputs func(int) []int) []int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, count)
+	// path holds the nodes being visited, every node takes input from the next one
+	path := make([]int, 0)
+
+	var visit func(int) []int
+	visit = func(i int) []int {
+		state[i] = visiting
+		path = append(path, i)
+		for _, input := range inputs(i) {
+			switch state[input] {
+			case visiting:
+				cycle := []int{input}
+				for j := len(path) - 1; path[j] != input; j-- {
+					cycle = append(cycle, path[j])
+				}
+				return append(cycle, input)
+			case unvisited:
+				if cycle := visit(input); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+
+	for i := 0; i < count; i++ {
+		if state[i] == unvisited {
+			if cycle := visit(i); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// readyToBeCalculated returns all the nodes which already has all inputs calculated
+func readyToBeCalculated(count int, inputs func(int) []int, calculated []bool) []int {
+	allInputsCalculated := func(inputs []int) bool {
+		for _, input := range inputs {
+			if !calculated[input] {
+				return false
+			}
+		}
+		return true
+	}
+
+	result := make([]int, 0)
+	for i := 0; i < count; i++ {
+		if calculated[i] {
+			continue
+		}
+		if allInputsCalculated(inputs(i)) {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// Layers groups nodes so that every node depends only on the nodes from the previous groups
+func Layers(count int, inputs func(int) []int) ([][]int, error) {
+	calculated := make([]bool, count)
+	calculatedCount := 0
+	result := make([][]int, 0)
+	for calculatedCount < count {
+		readyIndices := readyToBeCalculated(count, inputs, calculated)
+		if len(readyIndices) == 0 {
+			return nil, fmt.Errorf("none of the nodes left can be calculated, calculated %d of %d", calculatedCount, count)
+		}
+		result = append(result, readyIndices)
+		for _, idx := range readyIndices {
+			calculated[idx] = true
+		}
+		calculatedCount += len(readyIndices)
+	}
+	return result, nil
+}
@@ -0,0 +1,93 @@
+// Package errs defines error types returned by both the compose and the builder packages
+package errs
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Step identifies a function passed to chain or stack by its index,
+// or a graph node by its index and description
+type Step struct {
+	// Index is the index of the function or the node, -1 if unknown
+	Index int
+	// Node describes the graph node, empty for chains and stacks
+	Node string
+}
+
+func (s Step) String() string {
+	switch {
+	case s.Node != "":
+		return fmt.Sprintf("node %s", s.Node)
+	case s.Index >= 0:
+		return fmt.Sprintf("function at index %d", s.Index)
+	default:
+		return "function"
+	}
+}
+
+// NotAFunctionError is returned when a function is expected but something else is given
+type NotAFunctionError struct {
+	Step
+	// Type is the type of the given value, nil for untyped nil
+	Type reflect.Type
+	// Nil is set when the function is of a function type but nil
+	Nil bool
+}
+
+func (e *NotAFunctionError) Error() string {
+	if e.Nil {
+		return fmt.Sprintf("%v is nil", e.Step)
+	}
+	return fmt.Sprintf("expected %v at %v, got %v", reflect.Func, e.Step, e.Type)
+}
+
+// ArityMismatchError is returned when a function is given a wrong number of values
+type ArityMismatchError struct {
+	// Step takes the values
+	Step
+	// Function is the type of the function taking the values
+	Function reflect.Type
+	// Given is the number of values given, Expected is the number of arguments taken
+	Given, Expected int
+}
+
+func (e *ArityMismatchError) Error() string {
+	return fmt.Sprintf("%v takes %d arguments, got %d values", e.Step, e.Expected, e.Given)
+}
+
+// TypeMismatchError is returned when a value can't be passed as an argument of a function
+type TypeMismatchError struct {
+	// Step takes the value
+	Step
+	// Arg is the index of the argument
+	Arg int
+	// Given is the type of the value, Expected is the type of the argument
+	Given, Expected reflect.Type
+}
+
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("arg #%d: %v is not assignable to %v", e.Arg, e.Given, e.Expected)
+}
+
+// CycleError is returned when graph nodes depend on each other
+type CycleError struct {
+	// Nodes are indices of the nodes in the data flow order, the first node is repeated at the end
+	Nodes []int
+	// Descriptions describe the nodes
+	Descriptions []string
+}
+
+// NewCycleError returns the error for the nodes of the cycle described by describe
+func NewCycleError(nodes []int, describe func(int) string) *CycleError {
+	descriptions := make([]string, 0, len(nodes))
+	for _, idx := range nodes {
+		descriptions = append(descriptions, describe(idx))
+	}
+	return &CycleError{Nodes: nodes, Descriptions: descriptions}
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("graph has a cycle: %s", strings.Join(e.Descriptions, " -> "))
+}
@@ -0,0 +1,44 @@
+// Package funcinfo describes functions for error messages and graph exports
+package funcinfo
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+)
+
+func function(fn interface{}) *runtime.Func {
+	value := reflect.ValueOf(fn)
+	if value.Kind() != reflect.Func || value.IsNil() {
+		return nil
+	}
+	return runtime.FuncForPC(value.Pointer())
+}
+
+// Name returns the full name of the function, e.g. github.com/grihabor/gush.Chain
+func Name(fn interface{}) string {
+	f := function(fn)
+	if f == nil {
+		return fmt.Sprint(reflect.TypeOf(fn))
+	}
+	return f.Name()
+}
+
+// Location returns the file and the line where the function is defined
+func Location(fn interface{}) (string, int) {
+	f := function(fn)
+	if f == nil {
+		return "", 0
+	}
+	return f.FileLine(f.Entry())
+}
+
+// Describe returns the function name followed by its location
+func Describe(fn interface{}) string {
+	file, line := Location(fn)
+	if file == "" {
+		return Name(fn)
+	}
+	return fmt.Sprintf("%s (%s:%d)", Name(fn), filepath.Base(file), line)
+}
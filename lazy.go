@@ -0,0 +1,75 @@
+package compose
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Outputs lists indices of the values requested from a lazy function, see Lazy.
+// The trailing error doesn't count, nil requests all the values.
+type Outputs []int
+
+var outputsType = reflect.TypeOf(Outputs(nil))
+
+// Lazy makes SafeCompile build a function which takes Outputs after the context, if any,
+// and calls only the nodes needed to calculate the requested values,
+// the values which are not requested are zero. Requesting a value the graph doesn't return
+// fails with an error if the function returns one and panics otherwise.
+// Only the ops defined in this package are supported and the option has no effect on chains and stacks.
+func Lazy() Option {
+	return func(o *options) {
+		o.lazy = true
+	}
+}
+
+// dependencies returns the nodes needed to calculate every result of the plan
+func (pl *plan) dependencies() [][]int {
+	producers := make([]*planCall, pl.slots)
+	for i := range pl.layers {
+		for j := range pl.layers[i] {
+			c := &pl.layers[i][j]
+			for _, slot := range c.out {
+				producers[slot] = c
+			}
+		}
+	}
+	needs := make([][]int, len(pl.results))
+	for i, slot := range pl.results {
+		seen := make(map[int]bool)
+		slots := []int{slot}
+		for len(slots) > 0 {
+			c := producers[slots[len(slots)-1]]
+			slots = slots[:len(slots)-1]
+			if c == nil || seen[c.node] {
+				continue
+			}
+			seen[c.node] = true
+			needs[i] = append(needs[i], c.node)
+			slots = append(slots, c.in...)
+		}
+	}
+	return needs
+}
+
+// request marks the requested results and the nodes needed for them
+func (pl *plan) request(outputs reflect.Value, buffers *planBuffers) error {
+	all := outputs.IsNil()
+	count := len(pl.results)
+	if !all {
+		count = outputs.Len()
+	}
+	for i := 0; i < count; i++ {
+		result := i
+		if !all {
+			result = int(outputs.Index(i).Int())
+		}
+		if result < 0 || result >= len(pl.results) {
+			return fmt.Errorf("requested output #%d, the graph returns %d values", result, len(pl.results))
+		}
+		buffers.requested[result] = true
+		for _, idx := range pl.needs[result] {
+			buffers.needed[idx] = true
+		}
+	}
+	return nil
+}
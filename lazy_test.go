@@ -0,0 +1,173 @@
+package compose
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lazyGraph returns a graph with the outputs sum and product of the inputs,
+// the node unused feeds none of them
+func lazyGraph(t *testing.T, calls counter, withError bool) G {
+	gb := NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	gb.Input("b", reflect.TypeOf(0))
+	if withError {
+		errNegative := errors.New("negative")
+		gb.Named("sum", func(a, b int) (int, error) {
+			calls["sum"]++
+			if a+b < 0 {
+				return 0, errNegative
+			}
+			return a + b, nil
+		}).Inputs("a", "b")
+		gb.Named("product", func(ctx context.Context, a, b int) (int, error) {
+			calls["product"]++
+			return a * b, nil
+		}).Inputs("a", "b")
+		gb.Named("unused", func(a int) (int, error) {
+			calls["unused"]++
+			return a, nil
+		}).Inputs("a")
+		gb.Named("double", func(sum int) (int, error) {
+			calls["double"]++
+			return 2 * sum, nil
+		}).Inputs("sum")
+	} else {
+		gb.Named("sum", func(a, b int) int {
+			calls["sum"]++
+			return a + b
+		}).Inputs("a", "b")
+		gb.Named("product", func(a, b int) int {
+			calls["product"]++
+			return a * b
+		}).Inputs("a", "b")
+		gb.Named("unused", func(a int) int {
+			calls["unused"]++
+			return a
+		}).Inputs("a")
+		gb.Named("double", func(sum int) int {
+			calls["double"]++
+			return 2 * sum
+		}).Inputs("sum")
+	}
+	gb.Outputs("double", "product")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+	return g
+}
+
+func TestLazy(t *testing.T) {
+	for _, ops := range []Ops{AllArgs{}, ParallelArgs{Limit: 1}} {
+		calls := counter{}
+		f := Compile(lazyGraph(t, calls, false), ops, Lazy()).(func(Outputs, int, int) (int, int))
+
+		double, product := f(Outputs{1}, 2, 3)
+		assert.Equal(t, []int{0, 6}, []int{double, product})
+		assert.Equal(t, counter{"product": 1}, calls)
+
+		double, product = f(Outputs{0}, 2, 3)
+		assert.Equal(t, []int{10, 0}, []int{double, product})
+		assert.Equal(t, counter{"product": 1, "sum": 1, "double": 1}, calls)
+
+		double, product = f(nil, 2, 3)
+		assert.Equal(t, []int{10, 6}, []int{double, product})
+		double, product = f(Outputs{}, 2, 3)
+		assert.Equal(t, []int{0, 0}, []int{double, product})
+		assert.Equal(t, counter{"product": 2, "sum": 2, "double": 2}, calls, "%T", ops)
+
+		assert.PanicsWithValue(t, "requested output #2, the graph returns 2 values", func() {
+			f(Outputs{2}, 2, 3)
+		})
+	}
+}
+
+func TestLazy_WithError(t *testing.T) {
+	calls := counter{}
+	f := Compile(lazyGraph(t, calls, true), ContextArgs{}, Lazy()).(func(context.Context, Outputs, int, int) (int, int, error))
+	ctx := context.Background()
+
+	// sum fails, but it is not needed for the product
+	double, product, err := f(ctx, Outputs{1}, -2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, -2}, []int{double, product})
+
+	double, product, err = f(ctx, nil, -2, 1)
+	assert.ErrorContains(t, err, "negative")
+	assert.Equal(t, []int{0, 0}, []int{double, product})
+	assert.Equal(t, counter{"product": 2, "sum": 1}, calls)
+
+	double, product, err = f(ctx, Outputs{0, 2}, 2, 3)
+	assert.EqualError(t, err, "requested output #2, the graph returns 2 values")
+	assert.Equal(t, []int{0, 0}, []int{double, product})
+	assert.Equal(t, counter{"product": 2, "sum": 1}, calls)
+}
+
+func TestCompile_PrunesUnusedNodes(t *testing.T) {
+	for _, ops := range []Ops{AllArgs{}, ParallelArgs{Limit: 1}, layered{AllArgs{}}} {
+		calls := counter{}
+		f := Compile(lazyGraph(t, calls, false), ops).(func(int, int) (int, int))
+		double, product := f(2, 3)
+		assert.Equal(t, []int{10, 6}, []int{double, product})
+		assert.Equal(t, counter{"product": 1, "sum": 1, "double": 1}, calls, "%T", ops)
+	}
+
+	calls := counter{}
+	f := Compile(lazyGraph(t, calls, true), ContextArgs{}).(func(context.Context, int, int) (int, int, error))
+	_, _, err := f(context.Background(), 2, 3)
+	assert.NoError(t, err)
+	assert.Zero(t, calls["unused"])
+
+	calls = counter{}
+	g := CompileDataflow(lazyGraph(t, calls, false), 1).(func(int, int) (int, int))
+	double, product := g(2, 3)
+	assert.Equal(t, []int{10, 6}, []int{double, product})
+	assert.Zero(t, calls["unused"])
+}
+
+func TestCompile_PrunesUnusedArgumentNodes(t *testing.T) {
+	calls := counter{}
+	gb := NewGraphBuilder()
+	gb.Named("a", func(x int) (int, error) {
+		calls["a"]++
+		return x + 1, nil
+	})
+	gb.Named("b", func(x int) (int, error) {
+		calls["b"]++
+		return x, nil
+	})
+	gb.Outputs("a")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	// b is not called, but the function still takes its argument
+	for _, ops := range []Ops{LastArgError{}, ContextArgs{}, layered{LastArgError{}}, layered{ContextArgs{}}} {
+		fn := reflect.ValueOf(Compile(g, ops))
+		args := []reflect.Value{reflect.ValueOf(1), reflect.ValueOf(2)}
+		if passesContext(ops) {
+			args = append([]reflect.Value{reflect.ValueOf(context.Background())}, args...)
+		}
+		results := fn.Call(args)
+		assert.Equal(t, 2, results[0].Interface(), "%T", ops)
+		assert.Nil(t, results[1].Interface())
+	}
+	f := Compile(g, ContextArgs{}, Lazy()).(func(context.Context, Outputs, int, int) (int, error))
+	result, err := f(context.Background(), nil, 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result)
+	dataflow := CompileDataflow(g, 1).(func(int, int) (int, error))
+	result, err = dataflow(1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result)
+	assert.Equal(t, counter{"a": 6}, calls)
+}
+
+func TestSafeCompile_LazyUnknownOps(t *testing.T) {
+	_, err := SafeCompile(lazyGraph(t, counter{}, false), layered{AllArgs{}}, Lazy())
+	assert.ErrorContains(t, err, "lazy evaluation needs the ops defined in this package, got compose.layered")
+	_, err = SafeCompileDataflow(lazyGraph(t, counter{}, false), 0, Lazy())
+	assert.ErrorContains(t, err, "dataflow functions don't support lazy evaluation")
+}
@@ -0,0 +1,164 @@
+package compose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// counter counts the calls of the functions by name
+type counter map[string]int
+
+func TestMemoize(t *testing.T) {
+	calls := counter{}
+	gb := NewGraphBuilder()
+	gb.Input("path", reflect.TypeOf(""))
+	gb.Input("n", reflect.TypeOf(0))
+	parse := gb.Named("parse", func(path string) string {
+		calls["parse"]++
+		return strings.ToUpper(path)
+	})
+	parse.Inputs("path")
+	parse.Memoize(2, nil)
+	gb.Named("render", func(config string, n int) string {
+		calls["render"]++
+		return fmt.Sprintf("%s:%d", config, n)
+	}).Inputs("parse", "n")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	f := Compile(g, AllArgs{}).(func(string, int) string)
+	assert.Equal(t, "A:1", f("a", 1))
+	assert.Equal(t, "A:2", f("a", 2))
+	assert.Equal(t, "B:3", f("b", 3))
+	assert.Equal(t, "A:4", f("a", 4))
+	assert.Equal(t, counter{"parse": 2, "render": 4}, calls)
+
+	// the results are cached by the graph, so they are shared by the compiled functions
+	calls = counter{}
+	f = Compile(g, layered{AllArgs{}}).(func(string, int) string)
+	assert.Equal(t, "B:1", f("b", 1))
+	assert.Equal(t, "A:1", f("a", 1))
+	assert.Equal(t, counter{"render": 2}, calls)
+
+	// the least recently used result is evicted
+	calls = counter{}
+	assert.Equal(t, "C:1", f("c", 1))
+	assert.Equal(t, "A:1", f("a", 1))
+	assert.Equal(t, "B:1", f("b", 1))
+	assert.Equal(t, counter{"parse": 2, "render": 3}, calls)
+}
+
+func TestMemoize_KeyFunc(t *testing.T) {
+	calls := 0
+	gb := NewGraphBuilder()
+	gb.Input("words", reflect.TypeOf([]string{}))
+	join := gb.Node(func(words []string) string {
+		calls++
+		return strings.Join(words, " ")
+	})
+	join.Inputs("words")
+	join.Memoize(1, func(args []interface{}) interface{} {
+		return fmt.Sprint(args...)
+	})
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	f := Compile(g, AllArgs{}).(func([]string) string)
+	assert.Equal(t, "a b", f([]string{"a", "b"}))
+	assert.Equal(t, "a b", f([]string{"a", "b"}))
+	assert.Equal(t, "b", f([]string{"b"}))
+	assert.Equal(t, "a b", f([]string{"a", "b"}))
+	assert.Equal(t, 3, calls)
+}
+
+func TestMemoize_NotComparable(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Input("words", reflect.TypeOf([]string{}))
+	join := gb.Named("join", func(words []string) string { return strings.Join(words, " ") })
+	join.Inputs("words")
+	join.Memoize(1, nil)
+	_, err := gb.SafeBuild()
+	assert.ErrorContains(t, err, `failed to memoize node "join"`)
+	assert.ErrorContains(t, err, "argument #0 of type []string is not comparable")
+}
+
+func TestMemoize_Size(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Input("n", reflect.TypeOf(0))
+	n := gb.Node(itoa)
+	n.Inputs("n")
+	n.Memoize(0, nil)
+	_, err := gb.SafeBuild()
+	assert.ErrorContains(t, err, "must cache at least one result, got size 0")
+}
+
+func itoa(n int) string { return fmt.Sprint(n) }
+
+func TestMemoize_Errors(t *testing.T) {
+	errOdd := errors.New("odd")
+	calls := 0
+	gb := NewGraphBuilder()
+	gb.Input("n", reflect.TypeOf(0))
+	half := gb.Named("half", func(ctx context.Context, n int) (int, error) {
+		calls++
+		if n%2 != 0 {
+			return 0, errOdd
+		}
+		return n / 2, nil
+	})
+	half.Inputs("n")
+	half.Memoize(4, nil)
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	f := Compile(g, ContextArgs{}).(func(context.Context, int) (int, error))
+	for i := 0; i < 2; i++ {
+		result, err := f(context.Background(), 4)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, result)
+		_, err = f(context.TODO(), 3)
+		assert.ErrorIs(t, err, errOdd)
+	}
+	// the context is not a part of the key, failed calls are repeated
+	assert.Equal(t, 3, calls)
+}
+
+func TestGenerate_Memoized(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Input("n", reflect.TypeOf(0))
+	n := gb.Node(itoa)
+	n.Inputs("n")
+	n.Memoize(1, nil)
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+	_, err = Generate(g, AllArgs{}, GenerateOptions{Package: "main", Name: "itoa"})
+	assert.ErrorContains(t, err, "can't generate memoized node")
+}
+
+func TestMemoize_NotComparableValue(t *testing.T) {
+	calls := 0
+	gb := NewGraphBuilder()
+	gb.Input("v", reflect.TypeOf((*interface{})(nil)).Elem())
+	describe := gb.Node(func(v interface{}) string {
+		calls++
+		return fmt.Sprint(v)
+	})
+	describe.Inputs("v")
+	describe.Memoize(2, nil)
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	f := Compile(g, AllArgs{}).(func(interface{}) string)
+	assert.Equal(t, "[1]", f([]int{1}))
+	assert.Equal(t, "[1]", f([]int{1}))
+	assert.Equal(t, "1", f(1))
+	assert.Equal(t, "1", f(1))
+	// slices can't be cached, comparable values still are
+	assert.Equal(t, 3, calls)
+}
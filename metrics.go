@@ -0,0 +1,152 @@
+package compose
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Call describes a finished call of a step, see Measure
+type Call struct {
+	StepInfo
+	Duration time.Duration
+	// Err is the error returned by the step as the last value, if any
+	Err error
+	// Panicked is set when the step panics, including the panics turned into errors by Recover
+	Panicked bool
+}
+
+// MetricsSink receives every call of the steps, it must be safe for concurrent use
+type MetricsSink interface {
+	Observe(call Call)
+}
+
+// Measure passes every call of the steps to the sink
+func Measure(sink MetricsSink) Option {
+	return Intercept(func(info StepInfo, args []reflect.Value, next func([]reflect.Value) []reflect.Value) []reflect.Value {
+		call := Call{StepInfo: info}
+		start := time.Now()
+		defer func() {
+			if r := recover(); r != nil {
+				call.Duration, call.Panicked = time.Since(start), true
+				sink.Observe(call)
+				panic(r)
+			}
+		}()
+		results := next(args)
+		call.Duration = time.Since(start)
+		if len(results) > 0 && isError(results[len(results)-1].Type()) {
+			call.Err = errorOf(results[len(results)-1])
+		}
+		var panicErr *PanicError
+		call.Panicked = errors.As(call.Err, &panicErr)
+		sink.Observe(call)
+		return results
+	})
+}
+
+// DefaultLatencyBuckets are upper bounds of the latency histogram buckets used by MetricsRecorder
+var DefaultLatencyBuckets = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// NodeMetrics are the metrics of a single step
+type NodeMetrics struct {
+	Info                  StepInfo
+	Calls, Errors, Panics int
+	// Latency counts the calls by duration, Latency[i] is the number of calls which took
+	// up to Buckets[i] and more than the previous bound, the last one counts the rest
+	Latency []int
+	Total   time.Duration
+}
+
+// Mean returns the mean duration of the calls
+func (m NodeMetrics) Mean() time.Duration {
+	if m.Calls == 0 {
+		return 0
+	}
+	return m.Total / time.Duration(m.Calls)
+}
+
+// MetricsRecorder is a MetricsSink keeping the metrics in memory
+type MetricsRecorder struct {
+	buckets []time.Duration
+
+	mu    sync.Mutex
+	steps map[Step]*NodeMetrics
+}
+
+// NewMetricsRecorder returns a recorder with the given upper bounds of the latency buckets
+// in ascending order, DefaultLatencyBuckets are used if none are given
+func NewMetricsRecorder(buckets ...time.Duration) *MetricsRecorder {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	return &MetricsRecorder{buckets: buckets, steps: make(map[Step]*NodeMetrics)}
+}
+
+// Buckets returns upper bounds of the latency buckets
+func (r *MetricsRecorder) Buckets() []time.Duration {
+	return r.buckets
+}
+
+func (r *MetricsRecorder) Observe(call Call) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.steps[call.Step]
+	if !ok {
+		m = &NodeMetrics{Info: call.StepInfo, Latency: make([]int, len(r.buckets)+1)}
+		r.steps[call.Step] = m
+	}
+	m.Calls++
+	if call.Err != nil {
+		m.Errors++
+	}
+	if call.Panicked {
+		m.Panics++
+	}
+	m.Latency[sort.Search(len(r.buckets), func(i int) bool { return call.Duration <= r.buckets[i] })]++
+	m.Total += call.Duration
+}
+
+// Metrics returns a snapshot of the metrics of every called step ordered by step index
+func (r *MetricsRecorder) Metrics() []NodeMetrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]NodeMetrics, 0, len(r.steps))
+	for _, m := range r.steps {
+		snapshot := *m
+		snapshot.Latency = append([]int{}, m.Latency...)
+		result = append(result, snapshot)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Info.Index != result[j].Info.Index {
+			return result[i].Info.Index < result[j].Info.Index
+		}
+		return result[i].Info.Node < result[j].Info.Node
+	})
+	return result
+}
+
+// Slowest returns the metrics of the step with the greatest mean duration
+func (r *MetricsRecorder) Slowest() (NodeMetrics, bool) {
+	var (
+		result NodeMetrics
+		found  bool
+	)
+	for _, m := range r.Metrics() {
+		if !found || m.Mean() > result.Mean() {
+			result, found = m, true
+		}
+	}
+	return result, found
+}
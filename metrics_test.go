@@ -0,0 +1,96 @@
+package compose
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSafeCompile_Measure(t *testing.T) {
+	errNegative := errors.New("negative")
+	gb := NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	gb.Named("slow", func(a int) (int, error) {
+		time.Sleep(2 * time.Millisecond)
+		return a, nil
+	}).Inputs("a")
+	gb.Named("check", func(a int) (int, error) {
+		if a < 0 {
+			return 0, errNegative
+		}
+		if a == 0 {
+			panic("zero")
+		}
+		return a, nil
+	}).Inputs("slow")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	recorder := NewMetricsRecorder(time.Millisecond, time.Hour)
+	compiled, err := SafeCompile(g, LastArgError{}, Recover(), Measure(recorder))
+	assert.NoError(t, err)
+	fn := compiled.(func(int) (int, error))
+	for _, a := range []int{1, 2, -1, 0} {
+		_, _ = fn(a)
+	}
+
+	metrics := recorder.Metrics()
+	if assert.Len(t, metrics, 2) {
+		slow, check := metrics[0], metrics[1]
+		assert.Equal(t, "slow", slow.Info.Name)
+		assert.Equal(t, 1, slow.Info.Layer)
+		assert.Equal(t, 4, slow.Calls)
+		assert.Equal(t, 0, slow.Errors)
+		assert.Equal(t, []int{0, 4, 0}, slow.Latency)
+
+		assert.Equal(t, "check", check.Info.Name)
+		assert.Equal(t, 4, check.Calls)
+		assert.Equal(t, 2, check.Errors)
+		assert.Equal(t, 1, check.Panics)
+		assert.Equal(t, 4, check.Latency[0]+check.Latency[1])
+	}
+	slowest, ok := recorder.Slowest()
+	assert.True(t, ok)
+	assert.Equal(t, "slow", slowest.Info.Name)
+	assert.GreaterOrEqual(t, slowest.Mean(), 2*time.Millisecond)
+}
+
+func TestSafeCompile_MeasureConcreteErrorType(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	gb.Named("check", func(a int) (int, *negativeError) {
+		if a < 0 {
+			return 0, &negativeError{value: a}
+		}
+		return a, nil
+	}).Inputs("a")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	recorder := NewMetricsRecorder()
+	fn := Compile(g, LastArgError{}, Measure(recorder)).(func(int) (int, error))
+	for _, a := range []int{1, 2, -1} {
+		_, _ = fn(a)
+	}
+	metrics := recorder.Metrics()
+	if assert.Len(t, metrics, 1) {
+		assert.Equal(t, 3, metrics[0].Calls)
+		assert.Equal(t, 1, metrics[0].Errors)
+	}
+}
+
+func TestWrap_MeasurePanic(t *testing.T) {
+	recorder := NewMetricsRecorder()
+	steps := Wrap([]interface{}{func(int) int { panic("boom") }}, Measure(recorder))
+	assert.Panics(t, func() { Chain(steps[0], double).(func(int) int)(1) })
+
+	metrics := recorder.Metrics()
+	if assert.Len(t, metrics, 1) {
+		assert.Equal(t, 1, metrics[0].Calls)
+		assert.Equal(t, 1, metrics[0].Panics)
+		assert.Len(t, metrics[0].Latency, len(DefaultLatencyBuckets)+1)
+	}
+}
@@ -0,0 +1,207 @@
+package compose
+
+import (
+	"fmt"
+	"reflect"
+	"runtime/debug"
+
+	"github.com/grihabor/gush/internal/funcinfo"
+)
+
+// Option changes how the steps of a composed function are called, see Wrap
+type Option func(*options)
+
+type options struct {
+	stepErrors   bool
+	stepArgs     bool
+	recover      bool
+	interceptors []Interceptor
+	tracer       Tracer
+	lazy         bool
+}
+
+// StepErrors wraps errors returned by the steps into *StepError identifying the failed step
+func StepErrors() Option {
+	return func(o *options) {
+		o.stepErrors = true
+	}
+}
+
+// StepArgs is StepErrors which also records the arguments the failed step was called with
+func StepArgs() Option {
+	return func(o *options) {
+		o.stepErrors = true
+		o.stepArgs = true
+	}
+}
+
+// Recover turns panics of the steps into *PanicError returned as the trailing error,
+// so every step must return error as the last value
+func Recover() Option {
+	return func(o *options) {
+		o.recover = true
+	}
+}
+
+// StepInfo describes the step an interceptor is called for
+type StepInfo struct {
+	Step
+	// Name is the name of the graph node, empty for unnamed nodes, chains and stacks
+	Name string
+	// Function is the name of the step function
+	Function string
+	// Type is the type of the step function
+	Type reflect.Type
+	// Layer is the layer of the graph node, -1 for chains and stacks
+	Layer int
+}
+
+// Interceptor is called instead of the step with its arguments,
+// next calls the step and returns its results
+type Interceptor func(info StepInfo, args []reflect.Value, next func([]reflect.Value) []reflect.Value) []reflect.Value
+
+// Intercept calls the interceptors around every step, the first one is the outermost.
+// Interceptors see errors and panics after StepErrors and Recover are applied.
+func Intercept(interceptors ...Interceptor) Option {
+	return func(o *options) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Wrap applies the options to every step, the result is passed to SafeChainWithError
+// or SafeStackWithError in place of the steps. With StepErrors or Recover the steps
+// returning a concrete error type, like *MyError, return error instead.
+func Wrap(steps []interface{}, opts ...Option) []interface{} {
+	result, err := SafeWrap(steps, opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return result
+}
+
+func SafeWrap(steps []interface{}, opts ...Option) ([]interface{}, error) {
+	if err := allFunctions(types(steps)); err != nil {
+		return nil, fmt.Errorf("can't wrap non functions: %w", err)
+	}
+	if err := nonNil(steps); err != nil {
+		return nil, fmt.Errorf("can't wrap nil functions: %w", err)
+	}
+	o := newOptions(opts)
+	result := make([]interface{}, 0, len(steps))
+	for i, step := range steps {
+		info := StepInfo{
+			Step:     Step{Index: i},
+			Function: funcinfo.Name(step),
+			Type:     reflect.TypeOf(step),
+			Layer:    -1,
+		}
+		wrapped, err := o.wrap(info, step)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wrap function at index %d: %w", i, err)
+		}
+		result = append(result, wrapped)
+	}
+	return result, nil
+}
+
+// wrap applies the options to a single function described by info
+func (o options) wrap(info StepInfo, fn interface{}) (interface{}, error) {
+	if !o.stepErrors && !o.recover && len(o.interceptors) == 0 && o.tracer == nil {
+		return fn, nil
+	}
+	fnType := reflect.TypeOf(fn)
+	call := reflect.ValueOf(fn).Call
+	if o.stepErrors || o.recover {
+		if fnType.NumOut() == 0 || !isError(fnType.Out(fnType.NumOut()-1)) {
+			return nil, fmt.Errorf("function must return error as the last argument, got %v", fnType)
+		}
+		if fnType.Out(fnType.NumOut()-1) != errorInterface {
+			// a concrete error type can't hold *StepError or *PanicError, so error is returned instead
+			call = returningError(call)
+			fnType = withErrorResult(fnType)
+		}
+	}
+	if o.recover {
+		call = recovering(info.Step, fn, call)
+	}
+	if o.stepErrors {
+		call = attributing(info.Step, fn, o.stepArgs, call)
+	}
+	for i := len(o.interceptors) - 1; i >= 0; i-- {
+		call = intercepting(info, o.interceptors[i], call)
+	}
+	if o.tracer != nil {
+		return tracing(o.tracer, info, fnType, call), nil
+	}
+	return reflect.MakeFunc(fnType, call).Interface(), nil
+}
+
+// returningError converts the last value returned by the call to error
+func returningError(call func([]reflect.Value) []reflect.Value) func([]reflect.Value) []reflect.Value {
+	return func(args []reflect.Value) []reflect.Value {
+		results := call(args)
+		results[len(results)-1] = asError(results[len(results)-1])
+		return results
+	}
+}
+
+// intercepting passes the call to the interceptor as next
+func intercepting(
+	info StepInfo, interceptor Interceptor, call func([]reflect.Value) []reflect.Value,
+) func([]reflect.Value) []reflect.Value {
+	return func(args []reflect.Value) []reflect.Value {
+		return interceptor(info, args, call)
+	}
+}
+
+// attributing wraps errors returned by the call of fn into *StepError
+func attributing(
+	step Step, fn interface{}, withArgs bool, call func([]reflect.Value) []reflect.Value,
+) func([]reflect.Value) []reflect.Value {
+	name := funcinfo.Name(fn)
+	file, line := funcinfo.Location(fn)
+	return func(args []reflect.Value) []reflect.Value {
+		results := call(args)
+		last := len(results) - 1
+		err, _ := results[last].Interface().(error)
+		if err == nil {
+			return results
+		}
+		stepErr := &StepError{Step: step, Function: name, File: file, Line: line, Err: err}
+		if withArgs {
+			stepErr.Args = make([]interface{}, 0, len(args))
+			for _, arg := range args {
+				stepErr.Args = append(stepErr.Args, arg.Interface())
+			}
+		}
+		results[last] = errorValue(stepErr)
+		return results
+	}
+}
+
+// recovering turns a panic during the call of fn into *PanicError returned with zero values
+func recovering(step Step, fn interface{}, call func([]reflect.Value) []reflect.Value) func([]reflect.Value) []reflect.Value {
+	name := funcinfo.Name(fn)
+	fnType := reflect.TypeOf(fn)
+	outTypes := make([]reflect.Type, 0, fnType.NumOut()-1)
+	for i := 0; i < fnType.NumOut()-1; i++ {
+		outTypes = append(outTypes, fnType.Out(i))
+	}
+	return func(args []reflect.Value) (results []reflect.Value) {
+		defer func() {
+			if r := recover(); r != nil {
+				panicErr := &PanicError{Step: step, Function: name, Value: r, Stack: debug.Stack()}
+				results = append(zeros(outTypes), errorValue(panicErr))
+			}
+		}()
+		return call(args)
+	}
+}
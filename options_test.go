@@ -0,0 +1,322 @@
+package compose
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrap_StepErrors(t *testing.T) {
+	errNegative := errors.New("negative")
+	steps := Wrap([]interface{}{
+		func(a int) (int, error) { return a - 10, nil },
+		func(a int) (string, error) {
+			if a < 0 {
+				return "", errNegative
+			}
+			return fmt.Sprint(a), nil
+		},
+	}, StepErrors())
+	fn := ChainWithError(steps...).(func(int) (string, error))
+
+	result, err := fn(15)
+	assert.NoError(t, err)
+	assert.Equal(t, "5", result)
+
+	result, err = fn(5)
+	assert.Equal(t, "", result)
+	assert.ErrorIs(t, err, errNegative)
+	var stepErr *StepError
+	if assert.ErrorAs(t, err, &stepErr) {
+		assert.Equal(t, 1, stepErr.Index)
+		assert.Contains(t, stepErr.Function, "TestWrap_StepErrors.func2")
+		assert.Contains(t, stepErr.File, "options_test.go")
+		assert.Nil(t, stepErr.Args)
+	}
+	assert.Regexp(t, `^function at index 1 .*TestWrap_StepErrors\.func2 \(options_test\.go:\d+\) failed: negative$`, err.Error())
+}
+
+func TestWrap_StepArgs(t *testing.T) {
+	errFailed := errors.New("failed")
+	steps := Wrap([]interface{}{
+		func(a int, b string) (int, error) { return a, errFailed },
+		func(a int) (int, error) { return a, nil },
+	}, StepArgs())
+	fn := ChainWithError(steps...).(func(int, string) (int, error))
+
+	_, err := fn(1, "b")
+	var stepErr *StepError
+	if assert.ErrorAs(t, err, &stepErr) {
+		assert.Equal(t, 0, stepErr.Index)
+		assert.Equal(t, []interface{}{1, "b"}, stepErr.Args)
+	}
+}
+
+func TestWrap_StepErrorsStack(t *testing.T) {
+	errFailed := errors.New("failed")
+	steps := Wrap([]interface{}{
+		func() (int, error) { return 1, nil },
+		func() (int, error) { return 0, errFailed },
+	}, StepErrors())
+	fn, err := SafeStackWithError(steps...)
+	assert.NoError(t, err)
+
+	_, _, err = fn.(func() (int, int, error))()
+	var stepErr *StepError
+	if assert.ErrorAs(t, err, &stepErr) {
+		assert.Equal(t, 1, stepErr.Index)
+	}
+}
+
+type negativeError struct {
+	value int
+}
+
+func (e *negativeError) Error() string {
+	return fmt.Sprintf("negative %d", e.value)
+}
+
+func checkPositive(a int) (int, *negativeError) {
+	if a < 0 {
+		return 0, &negativeError{value: a}
+	}
+	if a == 0 {
+		panic("zero")
+	}
+	return a, nil
+}
+
+func TestWrap_ConcreteErrorType(t *testing.T) {
+	steps := Wrap([]interface{}{checkPositive}, StepErrors(), Recover())
+	fn := steps[0].(func(int) (int, error))
+
+	result, err := fn(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result)
+
+	_, err = fn(-1)
+	var negativeErr *negativeError
+	if assert.ErrorAs(t, err, &negativeErr) {
+		assert.Equal(t, -1, negativeErr.value)
+	}
+	var stepErr *StepError
+	assert.ErrorAs(t, err, &stepErr)
+
+	_, err = fn(0)
+	var panicErr *PanicError
+	assert.ErrorAs(t, err, &panicErr)
+}
+
+func TestSafeCompile_ConcreteErrorType(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	gb.Named("check", checkPositive).Inputs("a")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	compiled, err := SafeCompile(g, LastArgError{}, StepErrors())
+	assert.NoError(t, err)
+	fn := compiled.(func(int) (int, error))
+	result, err := fn(2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result)
+	_, err = fn(-2)
+	assert.Regexp(t, `^node "check" .*\.checkPositive \(options_test\.go:\d+\) failed: negative -2$`, err.Error())
+
+	// the error is passed on by AllArgs, so wrapping it would change the type the node passes on
+	_, err = SafeCompile(g, AllArgs{}, StepErrors())
+	assert.ErrorContains(t, err, "options change the values node")
+}
+
+func TestWrap_NoOptions(t *testing.T) {
+	step := func(a int) int { return a }
+	steps := Wrap([]interface{}{step})
+	assert.Equal(t, 1, steps[0].(func(int) int)(1))
+}
+
+func TestSafeWrap_NoError(t *testing.T) {
+	_, err := SafeWrap([]interface{}{func() int { return 1 }}, StepErrors())
+	assert.EqualError(
+		t, err,
+		"failed to wrap function at index 0: function must return error as the last argument, got func() int",
+	)
+}
+
+func TestWrap_RecoverChain(t *testing.T) {
+	steps := Wrap([]interface{}{
+		func(a int) (int, error) { return a, nil },
+		func(a int) (int, error) {
+			var values []int
+			return values[a], nil
+		},
+	}, Recover())
+	fn := ChainWithError(steps...).(func(int) (int, error))
+
+	result, err := fn(1)
+	assert.Equal(t, 0, result)
+	var panicErr *PanicError
+	if assert.ErrorAs(t, err, &panicErr) {
+		assert.Equal(t, 1, panicErr.Index)
+		assert.Contains(t, panicErr.Function, "TestWrap_RecoverChain.func2")
+		assert.Contains(t, string(panicErr.Stack), "options_test.go")
+	}
+	// runtime errors are errors, so they are reachable as well
+	var runtimeErr interface{ RuntimeError() }
+	assert.ErrorAs(t, err, &runtimeErr)
+}
+
+func TestWrap_RecoverStack(t *testing.T) {
+	steps := Wrap([]interface{}{
+		func() (int, error) { panic("boom") },
+		func() (string, error) { return "ok", nil },
+	}, Recover(), StepErrors())
+	fn, err := SafeStackWithError(steps...)
+	assert.NoError(t, err)
+
+	a, b, err := fn.(func() (int, string, error))()
+	assert.Equal(t, 0, a)
+	assert.Equal(t, "", b)
+	var panicErr *PanicError
+	if assert.ErrorAs(t, err, &panicErr) {
+		assert.Equal(t, "boom", panicErr.Value)
+	}
+	var stepErr *StepError
+	if assert.ErrorAs(t, err, &stepErr) {
+		assert.Equal(t, 0, stepErr.Index)
+	}
+}
+
+func TestSafeCompile_Recover(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	gb.Named("ok", func(a int) (int, error) { return a, nil }).Inputs("a")
+	gb.Named("fail", func(a int) (int, error) { panic(fmt.Sprint("fail ", a)) }).Inputs("ok")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	compiled, err := SafeCompile(g, LastArgError{}, Recover())
+	assert.NoError(t, err)
+	result, err := compiled.(func(int) (int, error))(7)
+	assert.Equal(t, 0, result)
+	var panicErr *PanicError
+	if assert.ErrorAs(t, err, &panicErr) {
+		assert.Equal(t, "fail 7", panicErr.Value)
+		assert.Contains(t, panicErr.Node, `"fail"`)
+	}
+	assert.Regexp(t, `^node "fail" .*TestSafeCompile_Recover\.func2 \(options_test\.go:\d+\) panicked: fail 7$`, err.Error())
+}
+
+func TestSafeCompile_StepErrors(t *testing.T) {
+	errFailed := errors.New("failed")
+	gb := NewGraphBuilder()
+	gb.Named("a", func() (int, error) { return 1, nil })
+	gb.Named("b", func(int) (int, error) { return 0, errFailed }).Inputs("a")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	compiled, err := SafeCompile(g, LastArgError{}, StepErrors())
+	assert.NoError(t, err)
+	_, err = compiled.(func() (int, error))()
+	assert.ErrorIs(t, err, errFailed)
+	var stepErr *StepError
+	if assert.ErrorAs(t, err, &stepErr) {
+		assert.Equal(t, 1, stepErr.Index)
+		assert.Contains(t, stepErr.Node, `"b"`)
+	}
+}
+
+func TestSafeCompile_RecoverNoError(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Node(func() int { return 1 })
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	_, err = SafeCompile(g, AllArgs{}, Recover())
+	assert.ErrorContains(t, err, "function must return error as the last argument, got func() int")
+}
+
+// recorder is an interceptor recording the calls of the steps
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recorder) intercept(info StepInfo, args []reflect.Value, next func([]reflect.Value) []reflect.Value) []reflect.Value {
+	results := next(args)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, fmt.Sprintf(
+		"%d %q %d: %v -> %v", info.Index, info.Name, info.Layer, interfaces(args), interfaces(results),
+	))
+	return results
+}
+
+func interfaces(values []reflect.Value) []interface{} {
+	result := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		result = append(result, value.Interface())
+	}
+	return result
+}
+
+func TestWrap_Intercept(t *testing.T) {
+	var order []string
+	outer := func(info StepInfo, args []reflect.Value, next func([]reflect.Value) []reflect.Value) []reflect.Value {
+		order = append(order, "outer")
+		return next(args)
+	}
+	inner := func(info StepInfo, args []reflect.Value, next func([]reflect.Value) []reflect.Value) []reflect.Value {
+		order = append(order, "inner")
+		assert.Equal(t, reflect.TypeOf(double), info.Type)
+		assert.Contains(t, info.Function, "double")
+		// interceptors may change the arguments and the results
+		results := next([]reflect.Value{reflect.ValueOf(args[0].Interface().(int) + 1)})
+		return []reflect.Value{reflect.ValueOf(results[0].Interface().(int) + 1)}
+	}
+	steps := Wrap([]interface{}{double, double}, Intercept(outer), Intercept(inner))
+	fn := Chain(steps...).(func(int) int)
+
+	assert.Equal(t, 13, fn(1))
+	assert.Equal(t, []string{"outer", "inner", "outer", "inner"}, order)
+}
+
+func TestWrap_InterceptStack(t *testing.T) {
+	r := &recorder{}
+	steps := Wrap([]interface{}{double, halve}, Intercept(r.intercept))
+	a, b := Stack(steps...).(func(int, int) (int, int))(3, 4)
+
+	assert.Equal(t, 6, a)
+	assert.Equal(t, 2, b)
+	assert.Equal(t, []string{
+		`0 "" -1: [3] -> [6]`,
+		`1 "" -1: [4] -> [2]`,
+	}, r.calls)
+}
+
+func TestSafeCompile_Intercept(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	gb.Named("double", double).Inputs("a")
+	gb.Named("halve", halve).Inputs("double")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	r := &recorder{}
+	compiled, err := SafeCompile(g, AllArgs{}, Intercept(r.intercept))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, compiled.(func(int) int)(3))
+	assert.Equal(t, []string{
+		`1 "double" 1: [3] -> [6]`,
+		`2 "halve" 2: [6] -> [3]`,
+	}, r.calls)
+
+	r = &recorder{}
+	compiled, err = SafeCompileDataflow(g, 0, Intercept(r.intercept))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, compiled.(func(int) int)(3))
+	assert.Len(t, r.calls, 2)
+}
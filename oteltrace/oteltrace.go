@@ -0,0 +1,64 @@
+// Package oteltrace adapts OpenTelemetry tracers to compose.Tracer.
+// It is a separate module, so that the graphs don't depend on OpenTelemetry unless they are traced with it.
+package oteltrace
+
+import (
+	"context"
+	"fmt"
+
+	compose "github.com/grihabor/gush"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// New returns a tracer starting spans with the OpenTelemetry tracer, it is passed to compose.Trace
+func New(tracer trace.Tracer) compose.Tracer {
+	return otelTracer{tracer: tracer}
+}
+
+type otelTracer struct {
+	tracer trace.Tracer
+}
+
+func (t otelTracer) Start(
+	ctx context.Context, name string, attributes ...compose.Attribute,
+) (context.Context, compose.Span) {
+	ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(keyValues(attributes)...))
+	return ctx, otelSpan{span: span}
+}
+
+// keyValues converts attributes to OpenTelemetry ones
+func keyValues(attributes []compose.Attribute) []attribute.KeyValue {
+	result := make([]attribute.KeyValue, 0, len(attributes))
+	for _, a := range attributes {
+		switch value := a.Value.(type) {
+		case int:
+			result = append(result, attribute.Int(a.Key, value))
+		case int64:
+			result = append(result, attribute.Int64(a.Key, value))
+		case bool:
+			result = append(result, attribute.Bool(a.Key, value))
+		case float64:
+			result = append(result, attribute.Float64(a.Key, value))
+		case string:
+			result = append(result, attribute.String(a.Key, value))
+		default:
+			result = append(result, attribute.String(a.Key, fmt.Sprint(value)))
+		}
+	}
+	return result
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) SetError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s otelSpan) End() {
+	s.span.End()
+}
@@ -0,0 +1,103 @@
+package oteltrace
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	compose "github.com/grihabor/gush"
+)
+
+// recordingTracer keeps the started spans, everything else is done by the noop tracer
+type recordingTracer struct {
+	noop.Tracer
+	spans []*recordingSpan
+}
+
+func (t *recordingTracer) Start(
+	ctx context.Context, name string, opts ...trace.SpanStartOption,
+) (context.Context, trace.Span) {
+	config := trace.NewSpanStartConfig(opts...)
+	span := &recordingSpan{name: name, attributes: config.Attributes()}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+type recordingSpan struct {
+	noop.Span
+	name       string
+	attributes []attribute.KeyValue
+	errs       []error
+	status     codes.Code
+	ended      bool
+}
+
+func (s *recordingSpan) RecordError(err error, _ ...trace.EventOption) {
+	s.errs = append(s.errs, err)
+}
+
+func (s *recordingSpan) SetStatus(code codes.Code, _ string) {
+	s.status = code
+}
+
+func (s *recordingSpan) End(...trace.SpanEndOption) {
+	s.ended = true
+}
+
+func TestNew(t *testing.T) {
+	errOdd := errors.New("odd")
+	gb := compose.NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	gb.Named("check", func(a int) (int, error) {
+		if a%2 != 0 {
+			return 0, errOdd
+		}
+		return a, nil
+	}).Inputs("a")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	tracer := &recordingTracer{}
+	compiled, err := compose.SafeCompile(g, compose.ContextArgs{}, compose.Trace(New(tracer)))
+	assert.NoError(t, err)
+	fn := compiled.(func(context.Context, int) (int, error))
+
+	_, err = fn(context.Background(), 1)
+	assert.ErrorIs(t, err, errOdd)
+	if assert.Len(t, tracer.spans, 2) {
+		root, check := tracer.spans[0], tracer.spans[1]
+		assert.Equal(t, compose.GraphSpanName, root.name)
+		assert.Equal(t, "check", check.name)
+		assert.Contains(t, check.attributes, attribute.String(compose.AttributeNodeName, "check"))
+		assert.Contains(t, check.attributes, attribute.Int(compose.AttributeNodeLayer, 1))
+		assert.Equal(t, []error{errOdd}, check.errs)
+		assert.Equal(t, codes.Error, check.status)
+		assert.True(t, root.ended)
+		assert.True(t, check.ended)
+	}
+}
+
+func TestKeyValues(t *testing.T) {
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.Int("int", 1),
+		attribute.Int64("int64", 2),
+		attribute.Bool("bool", true),
+		attribute.Float64("float64", 0.5),
+		attribute.String("string", "s"),
+		attribute.String("other", "[1 2]"),
+	}, keyValues([]compose.Attribute{
+		{Key: "int", Value: 1},
+		{Key: "int64", Value: int64(2)},
+		{Key: "bool", Value: true},
+		{Key: "float64", Value: 0.5},
+		{Key: "string", Value: "s"},
+		{Key: "other", Value: []int{1, 2}},
+	}))
+}
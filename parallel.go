@@ -0,0 +1,110 @@
+package compose
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// ParallelArgs passes all the arguments like AllArgs,
+// but runs stacked functions concurrently
+type ParallelArgs struct {
+	// Limit is the maximum number of functions running at once, zero means no limit
+	Limit int
+}
+
+func (p ParallelArgs) Stack(functions ...interface{}) (interface{}, error) {
+	return SafeParallelStack(p.Limit, functions...)
+}
+
+func (p ParallelArgs) Chain(functions ...interface{}) (interface{}, error) {
+	return SafeChain(functions...)
+}
+
+func (p ParallelArgs) In(fn reflect.Type) ([]reflect.Type, error) {
+	return in(fn)
+}
+
+func (p ParallelArgs) Out(fn reflect.Type) ([]reflect.Type, error) {
+	return out(fn)
+}
+
+func (p ParallelArgs) Lift(fn interface{}) (interface{}, error) {
+	return fn, nil
+}
+
+func ParallelStack(limit int, steps ...interface{}) interface{} {
+	result, err := SafeParallelStack(limit, steps...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return result
+}
+
+// SafeParallelStack works like SafeStack, but calls the functions on separate goroutines,
+// at most limit at once. Outputs are returned in the order of the functions.
+func SafeParallelStack(limit int, steps ...interface{}) (interface{}, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("limit must not be negative, got %d", limit)
+	}
+	if err := canStack(steps); err != nil {
+		return nil, err
+	}
+	functions := types(steps)
+	inputTypes, err := mapEach(in, functions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get functions input types: %w", err)
+	}
+	outputTypes, err := mapEach(out, functions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get functions output types: %w", err)
+	}
+	outputFlatten := flatten(outputTypes)
+	result := reflect.FuncOf(flatten(inputTypes), outputFlatten, false)
+
+	// precompute calls and argument offsets to save time during execution
+	calls := make([]func(in []reflect.Value) []reflect.Value, 0)
+	inOffsets, outOffsets := make([]int, 1), make([]int, 1)
+	for i := 0; i < len(steps); i++ {
+		calls = append(calls, reflect.ValueOf(steps[i]).Call)
+		inOffsets = append(inOffsets, inOffsets[i]+len(inputTypes[i]))
+		outOffsets = append(outOffsets, outOffsets[i]+len(outputTypes[i]))
+	}
+
+	return reflect.MakeFunc(result, func(args []reflect.Value) []reflect.Value {
+		outputs := make([]reflect.Value, len(outputFlatten))
+		var semaphore chan struct{}
+		if limit > 0 {
+			semaphore = make(chan struct{}, limit)
+		}
+		// the first panic is propagated to the caller's goroutine
+		var (
+			wg        sync.WaitGroup
+			panicOnce sync.Once
+			panicked  interface{}
+		)
+		for i, call := range calls {
+			if semaphore != nil {
+				semaphore <- struct{}{}
+			}
+			wg.Add(1)
+			go func(i int, call func([]reflect.Value) []reflect.Value) {
+				defer wg.Done()
+				defer func() {
+					if semaphore != nil {
+						<-semaphore
+					}
+					if r := recover(); r != nil {
+						panicOnce.Do(func() { panicked = r })
+					}
+				}()
+				copy(outputs[outOffsets[i]:outOffsets[i+1]], call(args[inOffsets[i]:inOffsets[i+1]]))
+			}(i, call)
+		}
+		wg.Wait()
+		if panicked != nil {
+			panic(panicked)
+		}
+		return outputs
+	}).Interface(), nil
+}
@@ -0,0 +1,120 @@
+package compose
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grihabor/gush/builder"
+)
+
+func TestParallelStack(t *testing.T) {
+	// both functions wait for each other, so they must run concurrently
+	ping, pong := make(chan struct{}), make(chan struct{})
+	fn, err := SafeParallelStack(0,
+		func(a int) int { close(ping); <-pong; return a + 1 },
+		func(a int) int { <-ping; close(pong); return a * 2 },
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	a, b := fn.(func(int, int) (int, int))(5, 5)
+	assert.Equal(t, 6, a)
+	assert.Equal(t, 10, b)
+}
+
+// pairs makes the steps wait for each other in pairs, so they only finish if two of them
+// run at once, and counts the steps running at most
+type pairs struct {
+	meet                      chan struct{}
+	running, maxRunning, lost int32
+}
+
+func newPairs() *pairs {
+	return &pairs{meet: make(chan struct{})}
+}
+
+func (p *pairs) step(a int) int {
+	n := atomic.AddInt32(&p.running, 1)
+	for {
+		m := atomic.LoadInt32(&p.maxRunning)
+		if n <= m || atomic.CompareAndSwapInt32(&p.maxRunning, m, n) {
+			break
+		}
+	}
+	// the timeout only keeps a sequential implementation from hanging
+	select {
+	case p.meet <- struct{}{}:
+	case <-p.meet:
+	case <-time.After(time.Second):
+		atomic.AddInt32(&p.lost, 1)
+	}
+	atomic.AddInt32(&p.running, -1)
+	return a
+}
+
+func TestParallelStack_Limit(t *testing.T) {
+	p := newPairs()
+	fn, err := SafeParallelStack(2, p.step, p.step, p.step, p.step)
+	if !assert.NoError(t, err) {
+		return
+	}
+	a, b, c, d := fn.(func(int, int, int, int) (int, int, int, int))(1, 2, 3, 4)
+	assert.Equal(t, []int{1, 2, 3, 4}, []int{a, b, c, d})
+	assert.Equal(t, int32(2), p.maxRunning)
+	assert.Zero(t, p.lost)
+}
+
+func TestPlan_ParallelLimit(t *testing.T) {
+	p := newPairs()
+	gb := NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	for _, name := range []string{"w", "x", "y", "z"} {
+		gb.Named(name, p.step).Inputs("a")
+	}
+	gb.Outputs("w", "x", "y", "z")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	fn := Compile(g, ParallelArgs{Limit: 2}).(func(int) (int, int, int, int))
+	w, x, y, z := fn(1)
+	assert.Equal(t, []int{1, 1, 1, 1}, []int{w, x, y, z})
+	assert.Equal(t, int32(2), p.maxRunning)
+	assert.Zero(t, p.lost)
+}
+
+func TestParallelStack_Panic(t *testing.T) {
+	fn, err := SafeParallelStack(0,
+		func(a int) int { return a },
+		func(a int) int { panic("boom") },
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.PanicsWithValue(t, "boom", func() {
+		fn.(func(int, int) (int, int))(1, 2)
+	})
+}
+
+func TestNewGraph_ParallelArgs(t *testing.T) {
+	gb := builder.NewGraphBuilder()
+
+	src := func() int { return 42 }
+	gb.Node(func(a int) int { return a / 2 }).Inputs(src)
+	gb.Node(func(a int) int { return a / 3 }).Inputs(src)
+
+	g, err := gb.Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+	fn, err := SafeCompile(g, ParallelArgs{Limit: 2})
+	if !assert.NoError(t, err) {
+		return
+	}
+	a, b := fn.(func() (int, int))()
+	assert.Equal(t, 21, a)
+	assert.Equal(t, 14, b)
+}
@@ -0,0 +1,330 @@
+package compose
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// plan is the graph lowered to a list of calls reading and writing values in slots,
+// it is executed by a single function instead of chained glue and stack functions.
+// Only the ops defined in this package are lowered, their semantics are reproduced by run.
+type plan struct {
+	// slots is the number of values stored during a call
+	slots int
+	// args are the slots of the arguments of the resulting function except for the context
+	args []int
+	// layers are the calls of the nodes grouped the same way they are stacked
+	layers [][]planCall
+	// results are the slots of the returned values
+	results []int
+	// zeros are returned with an error
+	zeros []reflect.Value
+
+	withError, withContext bool
+	// parallel runs the calls of a layer concurrently, at most limit at once unless it is zero
+	parallel bool
+	limit    int
+	// checks[i] is set if the context is checked before layer i, the last one is after all the layers
+	checks []bool
+	// maxIn is the greatest number of arguments of a call
+	maxIn int
+	// lazy plans take Outputs and call only the nodes needed for them,
+	// needs[i] are the nodes needed to calculate result i, see Lazy
+	lazy  bool
+	needs [][]int
+	nodes int
+	// buffers keeps the slots and the arguments between calls
+	buffers sync.Pool
+}
+
+// planBuffers are reused by the calls of the plan
+type planBuffers struct {
+	slots, in []reflect.Value
+	errs      []error
+	// needed nodes and requested results of a lazy plan
+	needed, requested []bool
+}
+
+// planCall is a call of a single node
+type planCall struct {
+	// node is the index of the node in the graph
+	node int
+	call func([]reflect.Value) []reflect.Value
+	// withContext passes the context before the inputs
+	withContext bool
+	in, out     []int
+}
+
+// lower builds the plan of the prepared graph, false if the ops are not known
+func lower(p *prepared, lazy bool) (*plan, reflect.Type, bool) {
+	pl := &plan{lazy: lazy, nodes: len(p.fns)}
+	switch ops := p.ops.(type) {
+	case AllArgs:
+	case ParallelArgs:
+		pl.parallel, pl.limit = true, ops.Limit
+	case LastArgError:
+		pl.withError = true
+	case ContextArgs:
+		pl.withError, pl.withContext = true, true
+	default:
+		return nil, nil, false
+	}
+
+	// every value of a node gets its own slot, arguments come first
+	slots := make([][]int, len(p.fns))
+	inTypes := make([]reflect.Type, 0)
+	newSlots := func(count int) []int {
+		result := make([]int, count)
+		for i := range result {
+			result[i] = pl.slots
+			pl.slots++
+		}
+		return result
+	}
+	isArgument := make(map[int]bool)
+	for _, idx := range p.arguments {
+		isArgument[idx] = true
+		slots[idx] = newSlots(len(p.outTypes[idx]))
+		pl.args = append(pl.args, slots[idx]...)
+		inTypes = append(inTypes, p.inTypes[idx]...)
+	}
+	portSlots := func(pt port) []int {
+		if pt.index == allValues {
+			return slots[pt.node]
+		}
+		return slots[pt.node][pt.index : pt.index+1]
+	}
+	for i, indices := range p.layers {
+		calls := make([]planCall, 0, len(indices))
+		for _, idx := range indices {
+			if isArgument[idx] {
+				continue
+			}
+			var argSlots []int
+			if i == 0 && p.arguments == nil {
+				// nodes of the first layer take the arguments of the resulting function in order
+				argSlots = newSlots(len(p.inTypes[idx]))
+				pl.args = append(pl.args, argSlots...)
+				inTypes = append(inTypes, p.inTypes[idx]...)
+			}
+			if !p.called(idx) {
+				continue
+			}
+			c := planCall{
+				node:        idx,
+				call:        reflect.ValueOf(p.fns[idx]).Call,
+				withContext: pl.withContext && takesContext(reflect.TypeOf(p.fns[idx])),
+				in:          append(make([]int, 0, len(p.inTypes[idx])), argSlots...),
+			}
+			for _, pt := range p.inputs[idx] {
+				c.in = append(c.in, portSlots(pt)...)
+			}
+			slots[idx] = newSlots(len(p.outTypes[idx]))
+			c.out = slots[idx]
+			if len(c.in)+1 > pl.maxIn {
+				pl.maxIn = len(c.in) + 1
+			}
+			calls = append(calls, c)
+		}
+		pl.layers = append(pl.layers, calls)
+	}
+
+	outTypes := make([]reflect.Type, 0)
+	for _, pt := range p.outputPorts() {
+		pl.results = append(pl.results, portSlots(pt)...)
+		outTypes = append(outTypes, p.portTypes(pt)...)
+	}
+	pl.zeros = zeros(outTypes)
+	if pl.withError {
+		outTypes = append(outTypes, errorInterface)
+	}
+	if pl.lazy {
+		pl.needs = pl.dependencies()
+		inTypes = append([]reflect.Type{outputsType}, inTypes...)
+	}
+	if pl.withContext {
+		inTypes = append([]reflect.Type{contextInterface}, inTypes...)
+		// the chain checks the context before every step, glue functions included,
+		// but a single stacked layer is returned as is
+		steps := 2*len(p.layers) - 1
+		if p.outputs != nil {
+			steps++
+		}
+		pl.checks = make([]bool, len(p.layers)+1)
+		for i := range p.layers {
+			pl.checks[i] = steps > 1
+		}
+		pl.checks[len(p.layers)] = p.outputs != nil
+	}
+	pl.buffers.New = func() interface{} {
+		buffers := &planBuffers{slots: make([]reflect.Value, pl.slots), in: make([]reflect.Value, 0, pl.maxIn)}
+		if pl.lazy {
+			buffers.needed, buffers.requested = make([]bool, pl.nodes), make([]bool, len(pl.results))
+		}
+		return buffers
+	}
+	return pl, reflect.FuncOf(inTypes, outTypes, false), true
+}
+
+// run calls the nodes and returns the results of the graph.
+// The slice of the arguments is reused for the results if it is large enough,
+// otherwise a new one is allocated as reflect reads it after run returns, so it can't be pooled.
+// Apart from that reflect allocates the arguments and the values, run doesn't allocate anything itself.
+func (pl *plan) run(args []reflect.Value) []reflect.Value {
+	buffers := pl.buffers.Get().(*planBuffers)
+	defer pl.release(buffers)
+	all := args
+	var ctxValue reflect.Value
+	var ctx context.Context
+	if pl.withContext {
+		ctxValue = args[0]
+		ctx, _ = ctxValue.Interface().(context.Context)
+		args = args[1:]
+	}
+	var needed []bool
+	if pl.lazy {
+		if err := pl.request(args[0], buffers); err != nil {
+			if !pl.withError {
+				panic(err.Error())
+			}
+			buffers.errs = append(buffers.errs, err)
+			return pl.failed(all, buffers.errs)
+		}
+		needed = buffers.needed
+		args = args[1:]
+	}
+	slots := buffers.slots
+	for i, slot := range pl.args {
+		slots[slot] = args[i]
+	}
+	for i, calls := range pl.layers {
+		if pl.withContext && pl.checks[i] && ctx != nil && ctx.Err() != nil {
+			buffers.errs = append(buffers.errs, ctx.Err())
+			return pl.failed(all, buffers.errs)
+		}
+		if pl.parallel && len(calls) > 1 {
+			pl.runParallel(calls, slots, needed)
+			continue
+		}
+		for _, c := range calls {
+			if needed != nil && !needed[c.node] {
+				continue
+			}
+			if err := pl.runCall(c, ctxValue, slots, buffers.in); err != nil {
+				buffers.errs = append(buffers.errs, err)
+			}
+		}
+		if len(buffers.errs) > 0 {
+			return pl.failed(all, buffers.errs)
+		}
+	}
+	if pl.withContext && pl.checks[len(pl.layers)] && ctx != nil && ctx.Err() != nil {
+		buffers.errs = append(buffers.errs, ctx.Err())
+		return pl.failed(all, buffers.errs)
+	}
+
+	count := len(pl.results)
+	if pl.withError {
+		count++
+	}
+	results := reuse(all, count)
+	for i, slot := range pl.results {
+		if pl.lazy && !buffers.requested[i] {
+			results[i] = pl.zeros[i]
+			continue
+		}
+		results[i] = slots[slot]
+	}
+	if pl.withError {
+		results[count-1] = noError
+	}
+	return results
+}
+
+// release clears the buffers not to keep the values alive and puts them back to the pool
+func (pl *plan) release(buffers *planBuffers) {
+	for i := range buffers.slots {
+		buffers.slots[i] = reflect.Value{}
+	}
+	for i := range buffers.errs {
+		buffers.errs[i] = nil
+	}
+	buffers.errs = buffers.errs[:0]
+	for i := range buffers.needed {
+		buffers.needed[i] = false
+	}
+	for i := range buffers.requested {
+		buffers.requested[i] = false
+	}
+	pl.buffers.Put(buffers)
+}
+
+// runCall calls the node with the values from the slots and stores its results,
+// the error is returned separately. Arguments are collected in the buffer if it is not nil.
+func (pl *plan) runCall(c planCall, ctx reflect.Value, slots []reflect.Value, buffer []reflect.Value) error {
+	in := buffer[:0]
+	if c.withContext {
+		in = append(in, ctx)
+	}
+	for _, slot := range c.in {
+		in = append(in, slots[slot])
+	}
+	out := c.call(in)
+	for i := range in {
+		in[i] = reflect.Value{}
+	}
+	for i, slot := range c.out {
+		slots[slot] = out[i]
+	}
+	if pl.withError {
+		if err := errorOf(out[len(out)-1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runParallel calls the nodes like SafeParallelStack does, only the needed ones if needed is not nil
+func (pl *plan) runParallel(calls []planCall, slots []reflect.Value, needed []bool) {
+	var semaphore chan struct{}
+	if pl.limit > 0 {
+		semaphore = make(chan struct{}, pl.limit)
+	}
+	// the first panic is propagated to the caller's goroutine
+	var (
+		wg        sync.WaitGroup
+		panicOnce sync.Once
+		panicked  interface{}
+	)
+	for _, c := range calls {
+		if needed != nil && !needed[c.node] {
+			continue
+		}
+		if semaphore != nil {
+			semaphore <- struct{}{}
+		}
+		wg.Add(1)
+		go func(c planCall) {
+			defer wg.Done()
+			defer func() {
+				if semaphore != nil {
+					<-semaphore
+				}
+				if r := recover(); r != nil {
+					panicOnce.Do(func() { panicked = r })
+				}
+			}()
+			_ = pl.runCall(c, reflect.Value{}, slots, nil)
+		}(c)
+	}
+	wg.Wait()
+	if panicked != nil {
+		panic(panicked)
+	}
+}
+
+// failed returns zero values and the combined error
+func (pl *plan) failed(buffer []reflect.Value, errs []error) []reflect.Value {
+	return failure(buffer, pl.zeros, joinErrors(errs))
+}
@@ -0,0 +1,150 @@
+package compose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grihabor/gush/builder"
+)
+
+// layered hides the type of the ops, so the graph is compiled to chained layers instead of a plan
+type layered struct {
+	Ops
+}
+
+func (l layered) In(fn reflect.Type) ([]reflect.Type, error) {
+	return opsIn(l.Ops, fn)
+}
+
+func (l layered) Out(fn reflect.Type) ([]reflect.Type, error) {
+	return opsOut(l.Ops, fn)
+}
+
+func (l layered) Lift(fn interface{}) (interface{}, error) {
+	return opsLift(l.Ops, fn)
+}
+
+func add(a, b int) int { return a + b }
+
+// ladder returns a graph of size nodes where every node takes the two previous ones
+func ladder(t testing.TB, size int) *builder.Graph {
+	gb := NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	gb.Input("b", reflect.TypeOf(0))
+	prev := []interface{}{"a", "b"}
+	for i := 0; i < size; i++ {
+		name := fmt.Sprintf("n%d", i)
+		gb.Named(name, add).Inputs(prev[0], prev[1])
+		prev = []interface{}{prev[1], name}
+	}
+	gb.Outputs(prev[1], "n0")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+	return g
+}
+
+func TestPlan_SameAsLayered(t *testing.T) {
+	g := ladder(t, 20)
+	for _, ops := range []Ops{AllArgs{}, ParallelArgs{}, ParallelArgs{Limit: 1}} {
+		planned := Compile(g, ops).(func(int, int) (int, int))
+		chained := Compile(g, layered{ops}).(func(int, int) (int, int))
+		a, b := planned(1, 2)
+		c, d := chained(1, 2)
+		assert.Equal(t, []int{c, d}, []int{a, b})
+		assert.Equal(t, 3, b)
+	}
+}
+
+func TestPlan_SameAsLayeredWithError(t *testing.T) {
+	errOdd := errors.New("odd")
+	errBig := errors.New("big")
+	gb := NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	gb.Named("even", func(a int) (int, error) {
+		if a%2 != 0 {
+			return 0, errOdd
+		}
+		return a, nil
+	}).Inputs("a")
+	gb.Named("small", func(a int) (int, error) {
+		if a > 10 {
+			return 0, errBig
+		}
+		return a, nil
+	}).Inputs("a")
+	gb.Named("sum", func(ctx context.Context, a, b int) (int, error) { return a + b, nil }).Inputs("even", "small")
+	gb.Outputs("sum", "even")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	planned := Compile(g, ContextArgs{}).(func(context.Context, int) (int, int, error))
+	chained := Compile(g, layered{ContextArgs{}}).(func(context.Context, int) (int, int, error))
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, ctx := range []context.Context{context.Background(), cancelled, nil} {
+		for _, a := range []int{2, 3, 12, 13} {
+			x, y, err1 := planned(ctx, a)
+			z, w, err2 := chained(ctx, a)
+			assert.Equal(t, []int{z, w}, []int{x, y})
+			assert.Equal(t, err2, err1)
+		}
+	}
+	_, _, err = planned(context.Background(), 13)
+	assert.ErrorIs(t, err, errOdd)
+	assert.ErrorIs(t, err, errBig)
+}
+
+func TestPlan_ConcreteErrorType(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	gb.Named("check", func(a int) (int, *negativeError) {
+		if a < 0 {
+			return 0, &negativeError{value: a}
+		}
+		return a, nil
+	}).Inputs("a")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	compiled := Compile(g, LastArgError{}).(func(int) (int, error))
+	result, err := compiled(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result)
+	_, err = compiled(-1)
+	assert.EqualError(t, err, "negative -1")
+
+	session := NewSession(g, LastArgError{}, nil).Func().(func(int) (int, error))
+	result, err = session(2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result)
+}
+
+func TestPlan_FewerAllocations(t *testing.T) {
+	g := ladder(t, 20)
+	planned := Compile(g, AllArgs{}).(func(int, int) (int, int))
+	chained := Compile(g, layered{AllArgs{}}).(func(int, int) (int, int))
+	plannedAllocs := testing.AllocsPerRun(100, func() { planned(1, 2) })
+	chainedAllocs := testing.AllocsPerRun(100, func() { chained(1, 2) })
+	assert.Less(t, plannedAllocs, chainedAllocs/2)
+}
+
+func BenchmarkCompile_Plan(b *testing.B) {
+	fn := Compile(ladder(b, 20), AllArgs{}).(func(int, int) (int, int))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		fn(1, 2)
+	}
+}
+
+func BenchmarkCompile_Layered(b *testing.B) {
+	fn := Compile(ladder(b, 20), layered{AllArgs{}}).(func(int, int) (int, int))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		fn(1, 2)
+	}
+}
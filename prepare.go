@@ -0,0 +1,355 @@
+package compose
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/grihabor/gush/builder"
+	"github.com/grihabor/gush/internal/funcinfo"
+)
+
+const allValues = builder.AllValues
+
+// port refers to a value returned by a node, all of them if index is allValues
+type port struct {
+	node, index int
+}
+
+// withOutputs is implemented by graphs declaring their outputs, like builder.Graph
+type withOutputs interface {
+	Outputs() []builder.Port
+}
+
+// withPorts is implemented by graphs passing single values to the nodes, like builder.Graph
+type withPorts interface {
+	Ports(int) []builder.Port
+}
+
+// withMemoized is implemented by graphs caching results of the nodes, like builder.Graph
+type withMemoized interface {
+	Memoized(int) interface{}
+}
+
+// memoized returns the function caching results of the node, nil if there is none
+func memoized(g G, idx int) interface{} {
+	if m, ok := g.(withMemoized); ok {
+		return m.Memoized(idx)
+	}
+	return nil
+}
+
+// withArguments is implemented by graphs declaring their inputs, like builder.Graph
+type withArguments interface {
+	Arguments() []int
+}
+
+// prepared is a validated graph with everything needed to compile it
+type prepared struct {
+	g   G
+	ops Ops
+	// fns are functions of the nodes
+	fns []interface{}
+	// inTypes and outTypes are types every node takes and passes on according to ops
+	inTypes, outTypes [][]reflect.Type
+	// inputs list values passed to every node
+	inputs [][]port
+	// arguments are the nodes passing on declared inputs of the graph, nil if not declared
+	arguments []int
+	// outputs list values returned by the compiled function,
+	// nil means all values of the last layer
+	outputs []port
+	layers  [][]int
+	// live marks the nodes which are called, nil if all of them are.
+	// The first layer keeps the nodes taking undeclared arguments even if they are not called.
+	live []bool
+}
+
+// prepare validates the graph and applies the options to every node except for the arguments
+func prepare(g G, ops Ops, opts options) (*prepared, error) {
+	if err := validate(g); err != nil {
+		return nil, fmt.Errorf("invalid graph: %w", err)
+	}
+	p := &prepared{g: g, ops: ops}
+	isArgument := make(map[int]bool)
+	if a, ok := g.(withArguments); ok {
+		p.arguments = a.Arguments()
+		for _, idx := range p.arguments {
+			if idx < 0 || idx >= g.NodeCount() || len(g.Inputs(idx)) > 0 {
+				return nil, fmt.Errorf("invalid graph: argument node #%d must exist and have no inputs", idx)
+			}
+			isArgument[idx] = true
+		}
+	}
+	for i := 0; i < g.NodeCount(); i++ {
+		fn := node(g, i)
+		fnType := reflect.TypeOf(fn)
+		if fnType == nil || fnType.Kind() != reflect.Func {
+			return nil, fmt.Errorf("invalid graph: %w", &NotAFunctionError{Step: nodeStep(g, i), Type: fnType})
+		}
+		if m := memoized(g, i); m != nil {
+			// memoized functions keep the types, options are applied around them
+			fn = m
+		}
+		if isArgument[i] {
+			// arguments just pass on their values, so they are adapted to ops
+			lifted, err := opsLift(ops, fn)
+			if err != nil {
+				return nil, fmt.Errorf("failed to lift argument node %s: %w", describeNode(g, i), err)
+			}
+			fn, fnType = lifted, reflect.TypeOf(lifted)
+		}
+		inTypes, err := opsIn(ops, fnType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get input types of node %s: %w", describeNode(g, i), err)
+		}
+		outTypes, err := opsOut(ops, fnType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get output types of node %s: %w", describeNode(g, i), err)
+		}
+		inputs := make([]port, 0)
+		if w, ok := g.(withPorts); ok {
+			for _, input := range w.Ports(i) {
+				inputs = append(inputs, port{node: input.Node, index: input.Index})
+			}
+		} else {
+			for _, input := range g.Inputs(i) {
+				inputs = append(inputs, port{node: input, index: allValues})
+			}
+		}
+		p.fns = append(p.fns, fn)
+		p.inTypes = append(p.inTypes, inTypes)
+		p.outTypes = append(p.outTypes, outTypes)
+		p.inputs = append(p.inputs, inputs)
+	}
+
+	if o, ok := g.(withOutputs); ok && o.Outputs() != nil {
+		p.outputs = make([]port, 0)
+		for _, output := range o.Outputs() {
+			pt := port{node: output.Node, index: output.Index}
+			if err := p.checkPort(pt); err != nil {
+				return nil, fmt.Errorf("invalid output: %w", err)
+			}
+			p.outputs = append(p.outputs, pt)
+		}
+	}
+
+	// make sure every node gets exactly the values it takes
+	for i, inputs := range p.inputs {
+		if len(inputs) == 0 {
+			continue
+		}
+		given := make([]reflect.Type, 0)
+		for _, pt := range inputs {
+			if err := p.checkPort(pt); err != nil {
+				return nil, fmt.Errorf("invalid input of node %s: %w", describeNode(g, i), err)
+			}
+			given = append(given, p.portTypes(pt)...)
+		}
+		if err := canPass(nodeStep(g, i), reflect.TypeOf(p.fns[i]), given, p.inTypes[i]); err != nil {
+			return nil, fmt.Errorf(
+				"can't pass outputs of %v to %v: %w",
+				types(g.Nodes(g.Inputs(i))), reflect.TypeOf(p.fns[i]), err,
+			)
+		}
+	}
+
+	layers, err := layers(g)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split graph into layers: %w", err)
+	}
+	p.layers = layers
+	if p.arguments != nil {
+		// declared arguments come first in the given order and no other node takes arguments
+		first := append([]int{}, p.arguments...)
+		for _, idx := range p.layers[0] {
+			if isArgument[idx] {
+				continue
+			}
+			if len(p.inTypes[idx]) > 0 {
+				return nil, fmt.Errorf(
+					"node %s takes arguments %v which are not declared as graph inputs",
+					describeNode(g, idx), p.inTypes[idx],
+				)
+			}
+			first = append(first, idx)
+		}
+		p.layers[0] = first
+	}
+	p.prune()
+
+	// options are applied last to know the layers, wrapped functions must pass on the same values,
+	// but tracing makes them take context first
+	for layer, indices := range p.layers {
+		for _, idx := range indices {
+			if isArgument[idx] || !p.called(idx) {
+				continue
+			}
+			info := StepInfo{
+				Step:     nodeStep(g, idx),
+				Name:     nodeName(g, idx),
+				Function: funcinfo.Name(p.fns[idx]),
+				Type:     reflect.TypeOf(p.fns[idx]),
+				Layer:    layer,
+			}
+			wrapped, err := opts.wrap(info, p.fns[idx])
+			if err != nil {
+				return nil, fmt.Errorf("failed to apply options to node %s: %w", describeNode(g, idx), err)
+			}
+			outTypes, err := opsOut(ops, reflect.TypeOf(wrapped))
+			if err != nil {
+				return nil, fmt.Errorf("failed to get output types of wrapped node %s: %w", describeNode(g, idx), err)
+			}
+			if !sameTypes(outTypes, p.outTypes[idx]) {
+				return nil, fmt.Errorf(
+					"options change the values node %s passes on from %v to %v",
+					describeNode(g, idx), p.outTypes[idx], outTypes,
+				)
+			}
+			p.fns[idx] = wrapped
+		}
+	}
+	return p, nil
+}
+
+// prune removes the nodes feeding none of the declared outputs from the layers.
+// Arguments are kept. Nodes taking undeclared arguments stay in the first layer as they define
+// the signature, but they are not called either, see called.
+func (p *prepared) prune() {
+	if p.outputs == nil {
+		return
+	}
+	live := make([]bool, len(p.fns))
+	pending := make([]int, 0)
+	for _, idx := range p.arguments {
+		live[idx] = true
+	}
+	for _, output := range p.outputs {
+		pending = append(pending, output.node)
+	}
+	for len(pending) > 0 {
+		idx := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if live[idx] {
+			continue
+		}
+		live[idx] = true
+		for _, input := range p.inputs[idx] {
+			pending = append(pending, input.node)
+		}
+	}
+
+	layers := make([][]int, 0, len(p.layers))
+	for i, indices := range p.layers {
+		kept := make([]int, 0, len(indices))
+		for _, idx := range indices {
+			if live[idx] || i == 0 && p.arguments == nil && len(p.inTypes[idx]) > 0 {
+				kept = append(kept, idx)
+			}
+		}
+		if len(kept) == 0 {
+			// inputs of a live node are live, so the layers after an empty one are empty too
+			break
+		}
+		layers = append(layers, kept)
+	}
+	if len(layers) == 0 {
+		// nothing is returned, the graph is kept as is to keep its signature
+		return
+	}
+	p.layers, p.live = layers, live
+}
+
+// called reports whether the node is called by the compiled function
+func (p *prepared) called(idx int) bool {
+	return p.live == nil || p.live[idx]
+}
+
+// sameTypes reports whether both lists hold the same types
+func sameTypes(a, b []reflect.Type) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *prepared) checkPort(pt port) error {
+	if pt.node < 0 || pt.node >= len(p.fns) {
+		return fmt.Errorf("unknown node #%d", pt.node)
+	}
+	if pt.index != allValues && (pt.index < 0 || pt.index >= len(p.outTypes[pt.node])) {
+		return fmt.Errorf(
+			"node %s passes on %d values, no value at index %d",
+			describeNode(p.g, pt.node), len(p.outTypes[pt.node]), pt.index,
+		)
+	}
+	return nil
+}
+
+// portTypes returns types of the values the port refers to
+func (p *prepared) portTypes(pt port) []reflect.Type {
+	if pt.index == allValues {
+		return p.outTypes[pt.node]
+	}
+	return p.outTypes[pt.node][pt.index : pt.index+1]
+}
+
+func (p *prepared) nodes(indices []int) []interface{} {
+	result := make([]interface{}, 0, len(indices))
+	for _, idx := range indices {
+		result = append(result, p.fns[idx])
+	}
+	return result
+}
+
+// outputPorts returns values returned by the compiled function
+func (p *prepared) outputPorts() []port {
+	if p.outputs != nil {
+		return p.outputs
+	}
+	result := make([]port, 0)
+	for _, idx := range p.layers[len(p.layers)-1] {
+		result = append(result, port{node: idx, index: allValues})
+	}
+	return result
+}
+
+// carried returns for every layer the nodes calculated in the previous layers
+// which outputs are still needed in the layers after it or in the outputs
+func (p *prepared) carried() [][]int {
+	layerOf := make(map[int]int)
+	for i, indices := range p.layers {
+		for _, idx := range indices {
+			layerOf[idx] = i
+		}
+	}
+	lastUse := make(map[int]int)
+	for i, inputs := range p.inputs {
+		for _, input := range inputs {
+			if layerOf[i] > lastUse[input.node] {
+				lastUse[input.node] = layerOf[i]
+			}
+		}
+	}
+	// outputs are used after the last layer
+	for _, output := range p.outputs {
+		lastUse[output.node] = len(p.layers)
+	}
+
+	result := make([][]int, len(p.layers))
+	result[0] = make([]int, 0)
+	for i := 1; i < len(p.layers); i++ {
+		result[i] = make([]int, 0)
+		donors := append(append([]int{}, p.layers[i-1]...), result[i-1]...)
+		for _, idx := range donors {
+			if lastUse[idx] > i {
+				result[i] = append(result[i], idx)
+			}
+		}
+	}
+	return result
+}
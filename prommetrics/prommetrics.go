@@ -0,0 +1,79 @@
+// Package prommetrics exposes metrics of compiled graphs to Prometheus.
+// It is a separate module, so that the graphs don't depend on the Prometheus client unless they are measured with it.
+package prommetrics
+
+import (
+	"strconv"
+
+	compose "github.com/grihabor/gush"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a compose.MetricsSink which is registered as a prometheus.Collector.
+// Metrics are labelled with the node name, or its function if the node is unnamed,
+// and with the node index.
+type Collector struct {
+	calls, errors, panics *prometheus.CounterVec
+	latency               *prometheus.HistogramVec
+}
+
+var labels = []string{"node", "index"}
+
+// NewCollector returns a collector of metrics named with the namespace,
+// buckets are upper bounds of the latency histogram in seconds, prometheus.DefBuckets if nil
+func NewCollector(namespace string, buckets []float64) *Collector {
+	if buckets == nil {
+		buckets = prometheus.DefBuckets
+	}
+	counter := func(name, help string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(
+			prometheus.CounterOpts{Namespace: namespace, Subsystem: "node", Name: name, Help: help},
+			labels,
+		)
+	}
+	return &Collector{
+		calls:  counter("calls_total", "Number of node calls."),
+		errors: counter("errors_total", "Number of node calls returning an error."),
+		panics: counter("panics_total", "Number of node calls which panicked."),
+		latency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Subsystem: "node",
+				Name:      "duration_seconds",
+				Help:      "Duration of node calls.",
+				Buckets:   buckets,
+			},
+			labels,
+		),
+	}
+}
+
+func (c *Collector) Observe(call compose.Call) {
+	node := call.Name
+	if node == "" {
+		node = call.Function
+	}
+	values := []string{node, strconv.Itoa(call.Index)}
+	c.calls.WithLabelValues(values...).Inc()
+	if call.Err != nil {
+		c.errors.WithLabelValues(values...).Inc()
+	}
+	if call.Panicked {
+		c.panics.WithLabelValues(values...).Inc()
+	}
+	c.latency.WithLabelValues(values...).Observe(call.Duration.Seconds())
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.calls.Describe(ch)
+	c.errors.Describe(ch)
+	c.panics.Describe(ch)
+	c.latency.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.calls.Collect(ch)
+	c.errors.Collect(ch)
+	c.panics.Collect(ch)
+	c.latency.Collect(ch)
+}
@@ -0,0 +1,55 @@
+package prommetrics
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	compose "github.com/grihabor/gush"
+)
+
+func TestCollector(t *testing.T) {
+	errNegative := errors.New("negative")
+	gb := compose.NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	gb.Named("check", func(a int) (int, error) {
+		if a < 0 {
+			return 0, errNegative
+		}
+		if a == 0 {
+			panic("zero")
+		}
+		return a, nil
+	}).Inputs("a")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	collector := NewCollector("test", []float64{60})
+	registry := prometheus.NewPedanticRegistry()
+	assert.NoError(t, registry.Register(collector))
+	compiled, err := compose.SafeCompile(g, compose.LastArgError{}, compose.Recover(), compose.Measure(collector))
+	assert.NoError(t, err)
+	fn := compiled.(func(int) (int, error))
+	for _, a := range []int{1, 2, -1, 0} {
+		_, _ = fn(a)
+	}
+
+	err = testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP test_node_calls_total Number of node calls.
+# TYPE test_node_calls_total counter
+test_node_calls_total{index="1",node="check"} 4
+# HELP test_node_errors_total Number of node calls returning an error.
+# TYPE test_node_errors_total counter
+test_node_errors_total{index="1",node="check"} 2
+# HELP test_node_panics_total Number of node calls which panicked.
+# TYPE test_node_panics_total counter
+test_node_panics_total{index="1",node="check"} 1
+`), "test_node_calls_total", "test_node_errors_total", "test_node_panics_total")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, testutil.CollectAndCount(collector, "test_node_duration_seconds"))
+}
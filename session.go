@@ -0,0 +1,175 @@
+package compose
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Session runs the graph again and again remembering the arguments and the values
+// returned by every node, so a node is called again only if any of its inputs changed
+// since the previous call. Values are compared with the equal function.
+// The nodes of a layer are called one by one even with ParallelArgs.
+type Session struct {
+	pl    *plan
+	fn    interface{}
+	equal func(a, b interface{}) bool
+
+	mu sync.Mutex
+	// values are the slots of the previous call, valid if it succeeded
+	values     []reflect.Value
+	valid      bool
+	in, old    []reflect.Value
+	recomputed []int
+}
+
+func NewSession(g G, ops Ops, equal func(a, b interface{}) bool, opts ...Option) *Session {
+	s, err := SafeNewSession(g, ops, equal, opts...)
+	if err != nil {
+		panic(fmt.Sprintf("%v", err))
+	}
+	return s
+}
+
+// SafeNewSession prepares the graph like SafeCompile does, only the ops defined in this package
+// are supported. Values are compared with reflect.DeepEqual if equal is nil.
+func SafeNewSession(g G, ops Ops, equal func(a, b interface{}) bool, opts ...Option) (*Session, error) {
+	o := newOptions(opts)
+	if o.tracer != nil && !passesContext(ops) {
+		return nil, fmt.Errorf("tracing needs ops passing context to the nodes, like ContextArgs")
+	}
+	if o.lazy {
+		return nil, fmt.Errorf("sessions don't support lazy evaluation")
+	}
+	p, err := prepare(g, ops, o)
+	if err != nil {
+		return nil, err
+	}
+	pl, fnType, ok := lower(p, false)
+	if !ok {
+		return nil, fmt.Errorf("session can't run graph compiled with %T", ops)
+	}
+	if equal == nil {
+		equal = reflect.DeepEqual
+	}
+	maxOut := 0
+	for _, calls := range pl.layers {
+		for _, c := range calls {
+			if len(c.out) > maxOut {
+				maxOut = len(c.out)
+			}
+		}
+	}
+	s := &Session{
+		pl:     pl,
+		equal:  equal,
+		values: make([]reflect.Value, pl.slots),
+		in:     make([]reflect.Value, 0, pl.maxIn),
+		old:    make([]reflect.Value, maxOut),
+	}
+	s.fn = reflect.MakeFunc(fnType, s.run).Interface()
+	if o.tracer != nil {
+		if s.fn, err = traceGraph(o.tracer, s.fn); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Func returns the function running the graph in the session,
+// it has the same signature as the function compiled with the same ops
+func (s *Session) Func() interface{} {
+	return s.fn
+}
+
+// Recomputed returns indices of the nodes called by the last call in the order they are called
+func (s *Session) Recomputed() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int{}, s.recomputed...)
+}
+
+// Reset forgets the values, so every node is called by the next call
+func (s *Session) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.valid = false
+}
+
+// run works like plan.run, but calls only the nodes taking changed values.
+// If the call fails or panics, the values are forgotten.
+func (s *Session) run(args []reflect.Value) []reflect.Value {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pl := s.pl
+	all := args
+	var ctxValue reflect.Value
+	var ctx context.Context
+	if pl.withContext {
+		ctxValue = args[0]
+		ctx, _ = ctxValue.Interface().(context.Context)
+		args = args[1:]
+	}
+	fresh := !s.valid
+	s.valid = false
+	s.recomputed = s.recomputed[:0]
+
+	changed := make([]bool, pl.slots)
+	for i, slot := range pl.args {
+		changed[slot] = fresh || !s.equal(s.values[slot].Interface(), args[i].Interface())
+		s.values[slot] = args[i]
+	}
+	for i, calls := range pl.layers {
+		if pl.withContext && pl.checks[i] && ctx != nil && ctx.Err() != nil {
+			return pl.failed(all, []error{ctx.Err()})
+		}
+		var errs []error
+		for _, c := range calls {
+			if !fresh && !anyChanged(changed, c.in) {
+				continue
+			}
+			s.recomputed = append(s.recomputed, c.node)
+			for j, slot := range c.out {
+				s.old[j] = s.values[slot]
+			}
+			if err := pl.runCall(c, ctxValue, s.values, s.in); err != nil {
+				errs = append(errs, err)
+			}
+			for j, slot := range c.out {
+				changed[slot] = fresh || !s.equal(s.old[j].Interface(), s.values[slot].Interface())
+				s.old[j] = reflect.Value{}
+			}
+		}
+		if len(errs) > 0 {
+			return pl.failed(all, errs)
+		}
+	}
+	if pl.withContext && pl.checks[len(pl.layers)] && ctx != nil && ctx.Err() != nil {
+		return pl.failed(all, []error{ctx.Err()})
+	}
+	s.valid = true
+
+	count := len(pl.results)
+	if pl.withError {
+		count++
+	}
+	results := reuse(all, count)
+	for i, slot := range pl.results {
+		results[i] = s.values[slot]
+	}
+	if pl.withError {
+		results[count-1] = noError
+	}
+	return results
+}
+
+// anyChanged reports whether any of the slots changed
+func anyChanged(changed []bool, slots []int) bool {
+	for _, slot := range slots {
+		if changed[slot] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package compose
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sessionGraph returns a graph with the inputs a and b at #0 and #1,
+// upper at #2 taking a, repeat at #3 taking b and join at #4 taking both of them
+func sessionGraph(t *testing.T, calls counter) G {
+	gb := NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(""))
+	gb.Input("b", reflect.TypeOf(0))
+	gb.Named("upper", func(a string) string {
+		calls["upper"]++
+		return strings.ToUpper(a)
+	}).Inputs("a")
+	gb.Named("repeat", func(b int) int {
+		calls["repeat"]++
+		return b % 3
+	}).Inputs("b")
+	gb.Named("join", func(a string, n int) string {
+		calls["join"]++
+		return strings.Repeat(a, n)
+	}).Inputs("upper", "repeat")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+	return g
+}
+
+func TestSession(t *testing.T) {
+	calls := counter{}
+	s := NewSession(sessionGraph(t, calls), AllArgs{}, nil)
+	f := s.Func().(func(string, int) string)
+
+	assert.Equal(t, "ABAB", f("ab", 2))
+	assert.Equal(t, []int{2, 3, 4}, s.Recomputed())
+
+	assert.Equal(t, "ABAB", f("ab", 2))
+	assert.Equal(t, []int{}, s.Recomputed())
+
+	assert.Equal(t, "CDCD", f("cd", 2))
+	assert.Equal(t, []int{2, 4}, s.Recomputed())
+
+	// repeat returns the same value, so join is not called again
+	assert.Equal(t, "CDCD", f("cd", 5))
+	assert.Equal(t, []int{3}, s.Recomputed())
+	assert.Equal(t, counter{"upper": 2, "repeat": 2, "join": 2}, calls)
+
+	s.Reset()
+	assert.Equal(t, "CD", f("cd", 1))
+	assert.Equal(t, []int{2, 3, 4}, s.Recomputed())
+}
+
+func TestSession_Equal(t *testing.T) {
+	calls := counter{}
+	s := NewSession(sessionGraph(t, calls), AllArgs{}, func(a, b interface{}) bool {
+		if a, ok := a.(string); ok {
+			return strings.EqualFold(a, b.(string))
+		}
+		return a == b
+	})
+	f := s.Func().(func(string, int) string)
+	assert.Equal(t, "AB", f("ab", 1))
+	assert.Equal(t, "AB", f("AB", 1))
+	assert.Equal(t, []int{}, s.Recomputed())
+}
+
+func TestSession_Error(t *testing.T) {
+	errNegative := errors.New("negative")
+	calls := counter{}
+	gb := NewGraphBuilder()
+	gb.Input("n", reflect.TypeOf(0))
+	gb.Input("m", reflect.TypeOf(0))
+	gb.Named("check", func(ctx context.Context, n int) (int, error) {
+		calls["check"]++
+		if n < 0 {
+			return 0, errNegative
+		}
+		return n, nil
+	}).Inputs("n")
+	gb.Named("add", func(n, m int) (int, error) {
+		calls["add"]++
+		return n + m, nil
+	}).Inputs("check", "m")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	s := NewSession(g, ContextArgs{}, nil)
+	f := s.Func().(func(context.Context, int, int) (int, error))
+	ctx := context.Background()
+	result, err := f(ctx, 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result)
+	result, err = f(ctx, 1, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, result)
+	assert.Equal(t, []int{3}, s.Recomputed())
+
+	_, err = f(ctx, -1, 3)
+	assert.ErrorIs(t, err, errNegative)
+	// the values are forgotten after the failed call
+	result, err = f(ctx, 1, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, result)
+	assert.Equal(t, []int{2, 3}, s.Recomputed())
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = f(cancelled, 1, 3)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, counter{"check": 3, "add": 3}, calls)
+}
+
+func TestSafeNewSession_UnknownOps(t *testing.T) {
+	_, err := SafeNewSession(sessionGraph(t, counter{}), layered{AllArgs{}}, nil)
+	assert.ErrorContains(t, err, "session can't run graph compiled with compose.layered")
+}
@@ -1,6 +1,7 @@
 package compose
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 )
@@ -42,19 +43,33 @@ func Stack(steps ...interface{}) interface{} {
 }
 
 func allFunctions(types []reflect.Type) error {
-	for _, typ := range types {
-		if typ.Kind() != reflect.Func {
-			return fmt.Errorf("expected %v, got %v", reflect.Func, typ)
+	for i, typ := range types {
+		if typ == nil || typ.Kind() != reflect.Func {
+			return &NotAFunctionError{Step: Step{Index: i}, Type: typ}
 		}
 	}
 	return nil
 }
 
+func canStack(steps []interface{}) error {
+	if err := allFunctions(types(steps)); err != nil {
+		return fmt.Errorf("can't stack non functions %v: %w", steps, err)
+	}
+	if err := nonNil(steps); err != nil {
+		return fmt.Errorf("can't stack nil functions: %w", err)
+	}
+	return nil
+}
+
+// SafeStack builds a function taking the arguments of all the functions and returning
+// their outputs in the same order. The outputs are written over the arguments if they fit,
+// otherwise every call allocates a slice for them: reflect reads it after the function returns,
+// so it can't be taken from a pool.
 func SafeStack(steps ...interface{}) (interface{}, error) {
-	functions := types(steps)
-	if err := allFunctions(functions); err != nil {
-		return nil, fmt.Errorf("can't stack non functions %v: %w", steps, err)
+	if err := canStack(steps); err != nil {
+		return nil, err
 	}
+	functions := types(steps)
 	inputTypes, err := mapEach(in, functions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get functions input types: %w", err)
@@ -63,17 +78,22 @@ func SafeStack(steps ...interface{}) (interface{}, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get functions output types: %w", err)
 	}
-	result := reflect.FuncOf(flatten(inputTypes), flatten(outputTypes), false)
+	outputFlatten := flatten(outputTypes)
+	result := reflect.FuncOf(flatten(inputTypes), outputFlatten, false)
 
 	// precompute calls to save time during execution
 	calls := make([]func(in []reflect.Value) []reflect.Value, 0)
 	for i := 0; i < len(steps); i++ {
 		calls = append(calls, reflect.ValueOf(steps[i]).Call)
 	}
+	overwrite := inPlace(inputTypes, outputTypes, 0, 0)
 
 	return reflect.MakeFunc(result, func(args []reflect.Value) (results []reflect.Value) {
 		start := 0
-		outputs := make([]reflect.Value, 0)
+		outputs := args[:0]
+		if !overwrite {
+			outputs = make([]reflect.Value, 0, len(outputFlatten))
+		}
 		for i, call := range calls {
 			inputs := args[start : start+len(inputTypes[i])]
 			outputs = append(outputs, call(inputs)...)
@@ -83,6 +103,95 @@ func SafeStack(steps ...interface{}) (interface{}, error) {
 	}).Interface(), nil
 }
 
+func StackWithError(steps ...interface{}) interface{} {
+	result, err := SafeStackWithError(steps...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return result
+}
+
+// SafeStackWithError stacks functions returning error as the last argument.
+// The resulting function returns outputs of all the functions followed by
+// a single error. If any of the functions fail, all the outputs are zero values
+// and the error combines errors of every failed function.
+// Like in SafeStack, a slice is allocated for the results unless they fit into the arguments.
 func SafeStackWithError(steps ...interface{}) (interface{}, error) {
-	panic("not implemented")
+	if err := canStack(steps); err != nil {
+		return nil, err
+	}
+	functions := types(steps)
+	inputTypes, err := mapEach(in, functions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get functions input types: %w", err)
+	}
+	outputTypes, err := mapEach(outWithError, functions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get functions output types: %w", err)
+	}
+	outputFlatten := flatten(outputTypes)
+	result := reflect.FuncOf(flatten(inputTypes), append(outputFlatten, errorInterface), false)
+
+	// precompute calls to save time during execution
+	calls := make([]func(in []reflect.Value) []reflect.Value, 0)
+	for i := 0; i < len(steps); i++ {
+		calls = append(calls, reflect.ValueOf(steps[i]).Call)
+	}
+
+	// precompute empty result for the case when err != nil
+	emptyResult := zeros(outputFlatten)
+	overwrite := inPlace(inputTypes, outputTypes, 0, 1)
+
+	return reflect.MakeFunc(result, func(args []reflect.Value) (results []reflect.Value) {
+		start := 0
+		outputs := args[:0]
+		if !overwrite {
+			outputs = make([]reflect.Value, 0, len(emptyResult)+1)
+		}
+		errs := make([]error, 0)
+		for i, call := range calls {
+			inputs := args[start : start+len(inputTypes[i])]
+			values := call(inputs)
+			if err := errorOf(values[len(values)-1]); err != nil {
+				errs = append(errs, err)
+			}
+			outputs = append(outputs, values[:len(values)-1]...)
+			start += len(inputTypes[i])
+		}
+		if len(errs) == 0 {
+			return append(outputs, noError)
+		}
+		outputs = append(outputs[:0], emptyResult...)
+		return append(outputs, joinErrors(errs))
+	}).Interface(), nil
+}
+
+// inPlace reports whether the outputs of the stacked functions can be written over
+// the arguments of the resulting function, the first function taking the argument at shift.
+// It is the case when every function gets its arguments before they are overwritten
+// by the outputs of the functions before it, and extra values fit after the outputs.
+func inPlace(inputTypes, outputTypes [][]reflect.Type, shift, extra int) bool {
+	read, written := shift, 0
+	for i := range inputTypes {
+		read += len(inputTypes[i])
+		written += len(outputTypes[i])
+		if written > read {
+			return false
+		}
+	}
+	return written+extra <= read
+}
+
+// joinErrors combines errors into a single error value,
+// a single error is returned as is
+func joinErrors(errs []error) reflect.Value {
+	if len(errs) == 1 {
+		return errorValue(errs[0])
+	}
+	return errorValue(errors.Join(errs...))
+}
+
+// errorValue returns the value of the error interface type holding err
+func errorValue(err error) reflect.Value {
+	return reflect.ValueOf(&err).Elem()
 }
@@ -1,6 +1,7 @@
 package compose
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -18,3 +19,60 @@ func TestStack(t *testing.T) {
 	assert.Equal(t, 6, a)
 	assert.Equal(t, 10, b)
 }
+
+func TestStackWithError(t *testing.T) {
+	fn, err := SafeStackWithError(
+		func(a int) (int, error) { return a + 1, nil },
+		func(a int) (int, error) { return a * 2, nil },
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	a, b, err := fn.(func(int, int) (int, int, error))(5, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, a)
+	assert.Equal(t, 10, b)
+}
+
+func TestStackWithError_CombineErrors(t *testing.T) {
+	errFirst, errSecond := errors.New("first"), errors.New("second")
+	fn, err := SafeStackWithError(
+		func(a int) (int, error) { return a, errFirst },
+		func(a int) (int, error) { return a, nil },
+		func(a int) (int, error) { return a, errSecond },
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	a, b, c, err := fn.(func(int, int, int) (int, int, int, error))(1, 2, 3)
+	assert.ErrorIs(t, err, errFirst)
+	assert.ErrorIs(t, err, errSecond)
+	assert.Equal(t, []int{0, 0, 0}, []int{a, b, c})
+}
+
+func TestStackWithError_ConcreteErrorType(t *testing.T) {
+	fn := StackWithError(
+		func(a int) (int, *negativeError) { return a, nil },
+		func(a int) (int, *negativeError) {
+			if a < 0 {
+				return 0, &negativeError{value: a}
+			}
+			return a, nil
+		},
+	).(func(int, int) (int, int, error))
+
+	a, b, err := fn(1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, []int{a, b})
+
+	a, b, err = fn(1, -2)
+	assert.EqualError(t, err, "negative -2")
+	assert.Equal(t, []int{0, 0}, []int{a, b})
+}
+
+func TestStackWithError_NoError(t *testing.T) {
+	_, err := SafeStackWithError(
+		func(a int) int { return a },
+	)
+	assert.Error(t, err)
+}
@@ -0,0 +1,193 @@
+package compose
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Tracer starts spans for the compiled graph and its nodes, see Trace
+type Tracer interface {
+	// Start starts a span which is a child of the span in ctx if any,
+	// the returned context carries the started span
+	Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span)
+}
+
+// Span is a single traced call
+type Span interface {
+	// SetError marks the call as failed
+	SetError(err error)
+	End()
+}
+
+// Attribute describes a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// span attribute keys
+const (
+	AttributeNodeIndex    = "gush.node.index"
+	AttributeNodeName     = "gush.node.name"
+	AttributeNodeFunction = "gush.node.function"
+	AttributeNodeLayer    = "gush.node.layer"
+)
+
+// GraphSpanName is the name of the span for the whole call of the compiled function
+const GraphSpanName = "gush.graph"
+
+// Trace starts a span for every call of the compiled function and a child span for every node call.
+// The span is named after the node, or its function if the node is unnamed, and it is marked failed
+// if the node returns an error or panics.
+// Spans are passed with context, so every step is made to take context.Context first:
+// SafeCompile needs ContextArgs, chains and stacks made of wrapped steps need SafeChainContext
+// and SafeStackContext.
+func Trace(tracer Tracer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
+// tracing returns a function which takes context first and calls the function of type fnType
+// in a span started by the tracer
+func tracing(tracer Tracer, info StepInfo, fnType reflect.Type, call func([]reflect.Value) []reflect.Value) interface{} {
+	name := info.Name
+	if name == "" {
+		name = info.Function
+	}
+	attributes := []Attribute{
+		{Key: AttributeNodeIndex, Value: info.Index},
+		{Key: AttributeNodeFunction, Value: info.Function},
+	}
+	if info.Name != "" {
+		attributes = append(attributes, Attribute{Key: AttributeNodeName, Value: info.Name})
+	}
+	if info.Layer >= 0 {
+		attributes = append(attributes, Attribute{Key: AttributeNodeLayer, Value: info.Layer})
+	}
+
+	inTypes, _ := in(fnType)
+	outTypes, _ := out(fnType)
+	withContext := takesContext(fnType)
+	if !withContext {
+		inTypes = append([]reflect.Type{contextInterface}, inTypes...)
+	}
+	resultFuncType := reflect.FuncOf(inTypes, outTypes, false)
+	return reflect.MakeFunc(resultFuncType, func(args []reflect.Value) []reflect.Value {
+		ctx, span := tracer.Start(contextOf(args[0]), name, attributes...)
+		defer span.End()
+		if withContext {
+			args = append([]reflect.Value{reflect.ValueOf(&ctx).Elem()}, args[1:]...)
+		} else {
+			args = args[1:]
+		}
+		return traced(span, func() []reflect.Value { return call(args) })
+	}).Interface()
+}
+
+// traced marks the span failed if the call panics or returns an error as the last value
+func traced(span Span, call func() []reflect.Value) []reflect.Value {
+	defer func() {
+		if r := recover(); r != nil {
+			span.SetError(fmt.Errorf("panic: %v", r))
+			panic(r)
+		}
+	}()
+	results := call()
+	if len(results) > 0 && isError(results[len(results)-1].Type()) {
+		if err := errorOf(results[len(results)-1]); err != nil {
+			span.SetError(err)
+		}
+	}
+	return results
+}
+
+// contextOf returns the context passed as value, background context if it is nil
+func contextOf(value reflect.Value) context.Context {
+	if ctx, ok := value.Interface().(context.Context); ok && ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
+
+// traceGraph starts the span for every call of the compiled function,
+// which must take context first
+func traceGraph(tracer Tracer, compiled interface{}) (interface{}, error) {
+	fnType := reflect.TypeOf(compiled)
+	if !takesContext(fnType) {
+		return nil, fmt.Errorf("tracing needs the compiled function to take context first, got %v", fnType)
+	}
+	call := reflect.ValueOf(compiled).Call
+	return reflect.MakeFunc(fnType, func(args []reflect.Value) []reflect.Value {
+		ctx, span := tracer.Start(contextOf(args[0]), GraphSpanName)
+		defer span.End()
+		args = append([]reflect.Value{reflect.ValueOf(&ctx).Elem()}, args[1:]...)
+		return traced(span, func() []reflect.Value { return call(args) })
+	}).Interface(), nil
+}
+
+// SpanRecorder is a Tracer keeping all the spans in memory, useful in tests
+type SpanRecorder struct {
+	mu    sync.Mutex
+	spans []*RecordedSpan
+}
+
+// RecordedSpan is a span recorded by SpanRecorder
+type RecordedSpan struct {
+	recorder *SpanRecorder
+
+	Name       string
+	Attributes []Attribute
+	// Parent is the parent span, nil for the root ones
+	Parent     *RecordedSpan
+	Start, End time.Time
+	Err        error
+}
+
+type recordedSpanKey struct{}
+
+func (r *SpanRecorder) Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span) {
+	span := &RecordedSpan{recorder: r, Name: name, Attributes: attributes, Start: time.Now()}
+	span.Parent, _ = ctx.Value(recordedSpanKey{}).(*RecordedSpan)
+	r.mu.Lock()
+	r.spans = append(r.spans, span)
+	r.mu.Unlock()
+	return context.WithValue(ctx, recordedSpanKey{}, span), recordedSpan{span}
+}
+
+// Spans returns the spans in the order they are started
+func (r *SpanRecorder) Spans() []*RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*RecordedSpan{}, r.spans...)
+}
+
+// Duration returns the time the span took, zero if it is not ended yet
+func (s *RecordedSpan) Duration() time.Duration {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	if s.End.IsZero() {
+		return 0
+	}
+	return s.End.Sub(s.Start)
+}
+
+// recordedSpan updates the recorded span under the recorder lock
+type recordedSpan struct {
+	span *RecordedSpan
+}
+
+func (s recordedSpan) SetError(err error) {
+	s.span.recorder.mu.Lock()
+	defer s.span.recorder.mu.Unlock()
+	s.span.Err = err
+}
+
+func (s recordedSpan) End() {
+	s.span.recorder.mu.Lock()
+	defer s.span.recorder.mu.Unlock()
+	s.span.End = time.Now()
+}
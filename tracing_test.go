@@ -0,0 +1,137 @@
+package compose
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSafeCompile_Trace(t *testing.T) {
+	errOdd := errors.New("odd")
+	gb := NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	gb.Named("double", func(a int) (int, error) { return a * 2, nil }).Inputs("a")
+	gb.Named("check", func(ctx context.Context, a int) (int, error) {
+		assert.NotNil(t, ctx.Value(recordedSpanKey{}))
+		if a%4 != 0 {
+			return 0, errOdd
+		}
+		return a, nil
+	}).Inputs("double")
+	gb.Outputs("check")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	recorder := &SpanRecorder{}
+	compiled, err := SafeCompile(g, ContextArgs{}, Trace(recorder))
+	assert.NoError(t, err)
+	fn := compiled.(func(context.Context, int) (int, error))
+
+	result, err := fn(context.Background(), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, result)
+
+	spans := recorder.Spans()
+	if assert.Len(t, spans, 3) {
+		root, double, check := spans[0], spans[1], spans[2]
+		assert.Equal(t, GraphSpanName, root.Name)
+		assert.Nil(t, root.Parent)
+		assert.Equal(t, "double", double.Name)
+		assert.Equal(t, root, double.Parent)
+		assert.Equal(t, root, check.Parent)
+		assert.Contains(t, double.Attributes, Attribute{Key: AttributeNodeName, Value: "double"})
+		assert.Contains(t, double.Attributes, Attribute{Key: AttributeNodeLayer, Value: 1})
+		assert.Contains(t, check.Attributes, Attribute{Key: AttributeNodeLayer, Value: 2})
+		for _, span := range spans {
+			assert.NoError(t, span.Err)
+			assert.False(t, span.End.IsZero())
+		}
+		assert.GreaterOrEqual(t, root.Duration(), check.Duration())
+	}
+
+	_, err = fn(context.Background(), 1)
+	assert.ErrorIs(t, err, errOdd)
+	spans = recorder.Spans()
+	if assert.Len(t, spans, 6) {
+		assert.Equal(t, errOdd, spans[3].Err)
+		assert.NoError(t, spans[4].Err)
+		assert.Equal(t, errOdd, spans[5].Err)
+	}
+}
+
+func TestSafeCompile_TraceConcreteErrorType(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Input("a", reflect.TypeOf(0))
+	gb.Named("check", func(a int) (int, *negativeError) {
+		if a < 0 {
+			return 0, &negativeError{value: a}
+		}
+		return a, nil
+	}).Inputs("a")
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	recorder := &SpanRecorder{}
+	fn := Compile(g, ContextArgs{}, Trace(recorder)).(func(context.Context, int) (int, error))
+	_, err = fn(context.Background(), 1)
+	assert.NoError(t, err)
+	_, err = fn(context.Background(), -1)
+	assert.EqualError(t, err, "negative -1")
+	spans := recorder.Spans()
+	if assert.Len(t, spans, 4) {
+		assert.NoError(t, spans[1].Err)
+		assert.EqualError(t, spans[3].Err, "negative -1")
+	}
+}
+
+func TestSafeCompile_TracePanic(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Node(func() (int, error) { panic("boom") })
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	recorder := &SpanRecorder{}
+	compiled, err := SafeCompile(g, ContextArgs{}, Trace(recorder))
+	assert.NoError(t, err)
+	assert.PanicsWithValue(t, "boom", func() {
+		compiled.(func(context.Context) (int, error))(context.Background())
+	})
+	spans := recorder.Spans()
+	if assert.Len(t, spans, 2) {
+		assert.EqualError(t, spans[0].Err, "panic: boom")
+		assert.EqualError(t, spans[1].Err, "panic: boom")
+		assert.Contains(t, spans[1].Name, "TestSafeCompile_TracePanic.func1")
+	}
+}
+
+func TestSafeCompile_TraceWithoutContext(t *testing.T) {
+	gb := NewGraphBuilder()
+	gb.Node(func() (int, error) { return 1, nil })
+	g, err := gb.SafeBuild()
+	assert.NoError(t, err)
+
+	_, err = SafeCompile(g, LastArgError{}, Trace(&SpanRecorder{}))
+	assert.EqualError(t, err, "tracing needs ops passing context to the nodes, like ContextArgs")
+}
+
+func TestWrap_TraceChainContext(t *testing.T) {
+	recorder := &SpanRecorder{}
+	steps := Wrap([]interface{}{
+		func(a int) (int, error) { return a + 1, nil },
+		func(a int) (int, error) { return a * 2, nil },
+	}, Trace(recorder))
+	fn := ChainContext(steps...).(func(context.Context, int) (int, error))
+
+	result, err := fn(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, result)
+	spans := recorder.Spans()
+	if assert.Len(t, spans, 2) {
+		assert.Nil(t, spans[0].Parent)
+		assert.NotContains(t, spans[0].Attributes, Attribute{Key: AttributeNodeLayer, Value: -1})
+		assert.Contains(t, spans[1].Attributes, Attribute{Key: AttributeNodeIndex, Value: 1})
+	}
+}
@@ -0,0 +1,191 @@
+package compose
+
+import "fmt"
+
+// Typed versions of Chain, ChainWithError and Stack for the common arities.
+// Functions are validated the same way as in the reflective versions,
+// but the resulting functions call the given ones directly.
+
+func Chain2[A, B, C any](f1 func(A) B, f2 func(B) C) func(A) C {
+	fn, err := SafeChain2(f1, f2)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+func SafeChain2[A, B, C any](f1 func(A) B, f2 func(B) C) (func(A) C, error) {
+	if err := CanChain(f1, f2); err != nil {
+		return nil, fmt.Errorf("given functions can't be chained: %w", err)
+	}
+	return func(a A) C {
+		return f2(f1(a))
+	}, nil
+}
+
+func Chain3[A, B, C, D any](f1 func(A) B, f2 func(B) C, f3 func(C) D) func(A) D {
+	fn, err := SafeChain3(f1, f2, f3)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+func SafeChain3[A, B, C, D any](f1 func(A) B, f2 func(B) C, f3 func(C) D) (func(A) D, error) {
+	if err := CanChain(f1, f2, f3); err != nil {
+		return nil, fmt.Errorf("given functions can't be chained: %w", err)
+	}
+	return func(a A) D {
+		return f3(f2(f1(a)))
+	}, nil
+}
+
+func Chain4[A, B, C, D, E any](f1 func(A) B, f2 func(B) C, f3 func(C) D, f4 func(D) E) func(A) E {
+	fn, err := SafeChain4(f1, f2, f3, f4)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+func SafeChain4[A, B, C, D, E any](f1 func(A) B, f2 func(B) C, f3 func(C) D, f4 func(D) E) (func(A) E, error) {
+	if err := CanChain(f1, f2, f3, f4); err != nil {
+		return nil, fmt.Errorf("given functions can't be chained: %w", err)
+	}
+	return func(a A) E {
+		return f4(f3(f2(f1(a))))
+	}, nil
+}
+
+func ChainErr2[A, B, C any](f1 func(A) (B, error), f2 func(B) (C, error)) func(A) (C, error) {
+	fn, err := SafeChainErr2(f1, f2)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+func SafeChainErr2[A, B, C any](f1 func(A) (B, error), f2 func(B) (C, error)) (func(A) (C, error), error) {
+	if err := CanChainWithError(f1, f2); err != nil {
+		return nil, fmt.Errorf("given functions can't be chained with error: %w", err)
+	}
+	return func(a A) (c C, err error) {
+		b, err := f1(a)
+		if err != nil {
+			return c, err
+		}
+		result, err := f2(b)
+		if err != nil {
+			return c, err
+		}
+		return result, nil
+	}, nil
+}
+
+func ChainErr3[A, B, C, D any](
+	f1 func(A) (B, error), f2 func(B) (C, error), f3 func(C) (D, error),
+) func(A) (D, error) {
+	fn, err := SafeChainErr3(f1, f2, f3)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+func SafeChainErr3[A, B, C, D any](
+	f1 func(A) (B, error), f2 func(B) (C, error), f3 func(C) (D, error),
+) (func(A) (D, error), error) {
+	if err := CanChainWithError(f1, f2, f3); err != nil {
+		return nil, fmt.Errorf("given functions can't be chained with error: %w", err)
+	}
+	return func(a A) (d D, err error) {
+		b, err := f1(a)
+		if err != nil {
+			return d, err
+		}
+		c, err := f2(b)
+		if err != nil {
+			return d, err
+		}
+		result, err := f3(c)
+		if err != nil {
+			return d, err
+		}
+		return result, nil
+	}, nil
+}
+
+func ChainErr4[A, B, C, D, E any](
+	f1 func(A) (B, error), f2 func(B) (C, error), f3 func(C) (D, error), f4 func(D) (E, error),
+) func(A) (E, error) {
+	fn, err := SafeChainErr4(f1, f2, f3, f4)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+func SafeChainErr4[A, B, C, D, E any](
+	f1 func(A) (B, error), f2 func(B) (C, error), f3 func(C) (D, error), f4 func(D) (E, error),
+) (func(A) (E, error), error) {
+	if err := CanChainWithError(f1, f2, f3, f4); err != nil {
+		return nil, fmt.Errorf("given functions can't be chained with error: %w", err)
+	}
+	return func(a A) (e E, err error) {
+		b, err := f1(a)
+		if err != nil {
+			return e, err
+		}
+		c, err := f2(b)
+		if err != nil {
+			return e, err
+		}
+		d, err := f3(c)
+		if err != nil {
+			return e, err
+		}
+		result, err := f4(d)
+		if err != nil {
+			return e, err
+		}
+		return result, nil
+	}, nil
+}
+
+func Stack2[A1, R1, A2, R2 any](f1 func(A1) R1, f2 func(A2) R2) func(A1, A2) (R1, R2) {
+	fn, err := SafeStack2(f1, f2)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+func SafeStack2[A1, R1, A2, R2 any](f1 func(A1) R1, f2 func(A2) R2) (func(A1, A2) (R1, R2), error) {
+	if err := canStack([]interface{}{f1, f2}); err != nil {
+		return nil, err
+	}
+	return func(a1 A1, a2 A2) (R1, R2) {
+		return f1(a1), f2(a2)
+	}, nil
+}
+
+func Stack3[A1, R1, A2, R2, A3, R3 any](
+	f1 func(A1) R1, f2 func(A2) R2, f3 func(A3) R3,
+) func(A1, A2, A3) (R1, R2, R3) {
+	fn, err := SafeStack3(f1, f2, f3)
+	if err != nil {
+		panic(err.Error())
+	}
+	return fn
+}
+
+func SafeStack3[A1, R1, A2, R2, A3, R3 any](
+	f1 func(A1) R1, f2 func(A2) R2, f3 func(A3) R3,
+) (func(A1, A2, A3) (R1, R2, R3), error) {
+	if err := canStack([]interface{}{f1, f2, f3}); err != nil {
+		return nil, err
+	}
+	return func(a1 A1, a2 A2, a3 A3) (R1, R2, R3) {
+		return f1(a1), f2(a2), f3(a3)
+	}, nil
+}
@@ -0,0 +1,83 @@
+package compose
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChain3(t *testing.T) {
+	fn := Chain3(
+		func(a int) float64 { return float64(a) },
+		func(a float64) float64 { return a / 2 },
+		func(a float64) string { return fmt.Sprint(a) },
+	)
+	assert.Equal(t, "2.5", fn(5))
+}
+
+func TestChain2_Nil(t *testing.T) {
+	_, err := SafeChain2(func(a int) int { return a }, (func(int) int)(nil))
+	assert.EqualError(t, err, "given functions can't be chained: can't chain nil functions: function at index 1 is nil")
+
+	_, err = SafeChain(func(a int) int { return a }, (func(int) int)(nil))
+	assert.EqualError(t, err, "given functions can't be chained: can't chain nil functions: function at index 1 is nil")
+}
+
+func TestChainErr2(t *testing.T) {
+	fn := ChainErr2(
+		strconv.Atoi,
+		func(a int) (int, error) { return a * 2, nil },
+	)
+	result, err := fn("21")
+	assert.NoError(t, err)
+	assert.Equal(t, 42, result)
+
+	result, err = fn("twenty one")
+	assert.Error(t, err)
+	assert.Equal(t, 0, result)
+}
+
+func TestChainErr_SameAsChainWithError(t *testing.T) {
+	errFailed := errors.New("failed")
+	parse := func(s string) (int, error) { return strconv.Atoi(s) }
+	// the last step returns a value together with the error
+	check := func(a int) (int, error) {
+		if a > 10 {
+			return a, errFailed
+		}
+		return a, nil
+	}
+	add := func(a int) (int, error) { return a + 1, nil }
+
+	typed := []func(string) (int, error){
+		ChainErr2(parse, check),
+		ChainErr3(parse, add, check),
+		ChainErr4(parse, add, add, check),
+	}
+	untyped := []func(string) (int, error){
+		ChainWithError(parse, check).(func(string) (int, error)),
+		ChainWithError(parse, add, check).(func(string) (int, error)),
+		ChainWithError(parse, add, add, check).(func(string) (int, error)),
+	}
+	for i := range typed {
+		for _, s := range []string{"1", "42", "x"} {
+			result1, err1 := typed[i](s)
+			result2, err2 := untyped[i](s)
+			assert.Equal(t, result2, result1, "%d %s", i, s)
+			assert.Equal(t, err2, err1, "%d %s", i, s)
+		}
+	}
+}
+
+func TestStack2(t *testing.T) {
+	fn := Stack2(
+		func(a int) int { return a + 1 },
+		strconv.Itoa,
+	)
+	a, b := fn(5, 5)
+	assert.Equal(t, 6, a)
+	assert.Equal(t, "5", b)
+}